@@ -44,6 +44,7 @@ import (
 	"github.com/IBM/multicloud-operators-subscription-release/pkg/apis"
 	"github.com/IBM/multicloud-operators-subscription-release/pkg/controller"
 	"github.com/IBM/multicloud-operators-subscription-release/pkg/controller/helmchartsubscription"
+	"github.com/IBM/multicloud-operators-subscription-release/pkg/helmreposubscriber"
 )
 
 // Change below variables to serve metrics on different host or port.
@@ -76,12 +77,33 @@ func main() {
 		false,
 		"Disable the helmchart subscription controller")
 
+	pflag.CommandLine.StringVar(&helmchartsubscription.Options.WebhookAddr,
+		"webhook-addr",
+		"",
+		"Address of the webhook receiver triggering the sync of the helmchart subscriptions, ex: :8090, disabled if empty")
+
+	pflag.CommandLine.DurationVar(&helmreposubscriber.Options.DefaultPollInterval,
+		"default-poll-interval",
+		helmreposubscriber.Options.DefaultPollInterval,
+		"Interval between two checks of the source of the helmchart subscriptions not setting a pollInterval")
+
+	pflag.CommandLine.DurationVar(&helmreposubscriber.Options.MinPollInterval,
+		"min-poll-interval",
+		helmreposubscriber.Options.MinPollInterval,
+		"Minimum interval between two checks of the source of a helmchart subscription")
+
 	pflag.Parse()
 
 	defer klog.Flush()
 
 	printVersion()
 
+	err := helmreposubscriber.Options.Validate()
+	if err != nil {
+		klog.Error(err, " - Invalid poll intervals")
+		os.Exit(1)
+	}
+
 	namespace, err := k8sutil.GetWatchNamespace()
 	if err != nil {
 		klog.Error(err, " - Failed to get watch namespace")
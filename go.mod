@@ -10,6 +10,7 @@ require (
 	github.com/operator-framework/operator-sdk v0.12.0
 	github.com/spf13/pflag v1.0.3
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
 	golang.org/x/net v0.0.0-20190812203447-cdfb69ac37fc
 	gopkg.in/src-d/go-git.v4 v4.13.1
 	k8s.io/api v0.0.0
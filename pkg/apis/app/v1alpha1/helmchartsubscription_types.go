@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -28,6 +29,24 @@ import (
 //ChartsDir env variable name which contains the directory where the charts are installed
 const ChartsDir = "CHARTS_DIR"
 
+//ChartsCacheMaxSize env variable name which contains the maximum size of the charts cache, ex: 1Gi
+const ChartsCacheMaxSize = "CHARTS_CACHE_MAX_SIZE"
+
+//ChartsCacheMaxAge env variable name which contains how long an unused chart stays in the charts cache, ex: 168h
+const ChartsCacheMaxAge = "CHARTS_CACHE_MAX_AGE"
+
+//ChartsMaxDownloadSize env variable name which contains the maximum size of a downloaded chart archive, ex: 20Mi
+const ChartsMaxDownloadSize = "CHARTS_MAX_DOWNLOAD_SIZE"
+
+//ChartsMaxIndexSize env variable name which contains the maximum size of an index.yaml, ex: 64Mi
+const ChartsMaxIndexSize = "CHARTS_MAX_INDEX_SIZE"
+
+//WebhookSecret env variable name which contains the secret verifying the notifications of the webhook receiver
+const WebhookSecret = "WEBHOOK_SECRET"
+
+//HelmChartSubscriptionUIDLabel label set on the HelmReleases created by a subscription, the value is the uid of the subscription
+const HelmChartSubscriptionUIDLabel = "app.ibm.com/helmchartsubscription-uid"
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
@@ -56,11 +75,33 @@ type GitHubSubscription struct {
 	Urls       []string `json:"urls,omitempty"`
 	ChartsPath string   `json:"chartsPath,omitempty"`
 	Branch     string   `json:"branch,omitempty"`
+	// Tag to checkout, takes precedence over the branch
+	Tag string `json:"tag,omitempty"`
+	// Commit is the full SHA of the commit to checkout, takes precedence over the tag and the branch
+	Commit string `json:"commit,omitempty"`
 }
 
+//HelmRepoMode defines how the index.yaml of the different urls are combined
+type HelmRepoMode string
+
+const (
+	//HelmRepoModeFailover the index.yaml is retrieved from the first url which answers
+	HelmRepoModeFailover HelmRepoMode = "failover"
+	//HelmRepoModeMerge the index.yaml of all urls are merged, the first url takes precedence for a same chart version
+	HelmRepoModeMerge HelmRepoMode = "merge"
+)
+
 //HelmRepoSubscription provides the urls to retrieve the helm-chart
 type HelmRepoSubscription struct {
 	Urls []string `json:"urls,omitempty"`
+	// Mode failover (default) or merge
+	Mode HelmRepoMode `json:"mode,omitempty"`
+}
+
+//OCISubscription provides the chart repositories to subscribe to in an OCI registry
+type OCISubscription struct {
+	// Urls of the chart repositories oci://<registry>/<repository>, the tags are the chart versions
+	Urls []string `json:"urls,omitempty"`
 }
 
 //SourceSubscription holds the different types of repository
@@ -68,6 +109,7 @@ type SourceSubscription struct {
 	SourceType SourceTypeEnum        `json:"type,omitempty"`
 	GitHub     *GitHubSubscription   `json:"github,omitempty"`
 	HelmRepo   *HelmRepoSubscription `json:"helmRepo,omitempty"`
+	OCI        *OCISubscription      `json:"oci,omitempty"`
 }
 
 func (s SourceSubscription) String() string {
@@ -76,6 +118,8 @@ func (s SourceSubscription) String() string {
 		return fmt.Sprintf("%v", s.HelmRepo.Urls)
 	case string(GitHubSourceType):
 		return fmt.Sprintf("%v|%s|%s", s.GitHub.Urls, s.GitHub.Branch, s.GitHub.ChartsPath)
+	case string(OCISourceType):
+		return fmt.Sprintf("%v", s.OCI.Urls)
 	default:
 		return fmt.Sprintf("SourceType %s not supported", s.SourceType)
 	}
@@ -107,6 +151,36 @@ type HelmChartSubscriptionSpec struct {
 	SecretRef *corev1.ObjectReference `json:"secretRef,omitempty"`
 	// Configuration parameters to access the helm-repo defined in the CatalogSource
 	ConfigMapRef *corev1.ObjectReference `json:"configRef,omitempty"`
+	// Verification of the chart signature, propagated to the HelmReleases
+	Verification *ChartVerification `json:"verification,omitempty"`
+	// PollInterval between two checks of the source, the operator default is used if not set
+	// and the operator minimum if lower than it.
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+	// ApprovedUpgrades are the pending upgrades approved, in Manual mode a HelmRelease is installed
+	// once and only updated to an approved version
+	ApprovedUpgrades []PackageUpgrade `json:"approvedUpgrades,omitempty"`
+}
+
+//PackageUpgrade identifies a version of a package and the spec of its HelmRelease
+type PackageUpgrade struct {
+	PackageName string `json:"packageName"`
+	Version     string `json:"version"`
+	// SpecHash is the specHash of the approved pending upgrade
+	SpecHash string `json:"specHash"`
+}
+
+//PendingUpgrade is a version of a package waiting for an approval in Manual mode
+type PendingUpgrade struct {
+	// CurrentVersion is the version of the installed HelmRelease
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// Version is the candidate version
+	Version string `json:"version"`
+	// ValuesDiff lists the values removed (-) and added (+) by the upgrade
+	ValuesDiff string `json:"valuesDiff,omitempty"`
+	// SpecHash identifies the spec of the candidate HelmRelease, a change of the spec raises a new pending upgrade
+	SpecHash string `json:"specHash"`
+	// DetectionTime is when the upgrade was first found
+	DetectionTime metav1.Time `json:"detectionTime"`
 }
 
 //Approval approval types
@@ -136,6 +210,8 @@ type HelmChartSubscriptionUnitStatus struct {
 	Message        string                          `json:"message,omitempty"`
 	Reason         string                          `json:"reason,omitempty"`
 	LastUpdateTime metav1.Time                     `json:"lastUpdateTime"`
+	// Version is the chart version of the HelmRelease of a package
+	Version string `json:"version,omitempty"`
 }
 
 // HelmChartSubscriptionStatus defines the observed state of HelmChartSubscription
@@ -145,7 +221,17 @@ type HelmChartSubscriptionStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	HelmChartSubscriptionUnitStatus `json:",inline"`
 
+	// HelmChartSubscriptionPackageStatus is the status of the HelmRelease of each package
 	HelmChartSubscriptionPackageStatus map[string]HelmChartSubscriptionUnitStatus `json:"packages,omitempty"`
+
+	// ChartSources is the url of the helm repo which served each chart
+	ChartSources map[string]string `json:"chartSources,omitempty"`
+
+	// NextPollTime is the time of the next scheduled check of the source
+	NextPollTime *metav1.Time `json:"nextPollTime,omitempty"`
+
+	// PendingUpgrades are the upgrades waiting for an approval per package, in Manual mode
+	PendingUpgrades map[string]PendingUpgrade `json:"pendingUpgrades,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -171,14 +257,22 @@ type HelmChartSubscriptionList struct {
 	Items           []HelmChartSubscription `json:"items"`
 }
 
-// Subscriber defines the interface for various channels
+// Subscriber defines the interface for various channels, the subscribers stop when the context
+// passed to Restart or Update is cancelled
 type Subscriber interface {
-	Restart() error
+	Restart(context.Context) error
 	Stop() error
-	Update(*HelmChartSubscription) error
+	Update(context.Context, *HelmChartSubscription) error
 	IsStarted() bool
 }
 
+// SyncTrigger is implemented by the subscribers which can check their source on demand,
+// TriggerSync returns false if the source is not one of the urls or not on the branch.
+// An empty branch matches all the branches.
+type SyncTrigger interface {
+	TriggerSync(urls []string, branch string) bool
+}
+
 func init() {
 	SchemeBuilder.Register(&HelmChartSubscription{}, &HelmChartSubscriptionList{})
 }
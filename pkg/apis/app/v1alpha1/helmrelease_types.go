@@ -49,6 +49,8 @@ const (
 	HelmRepoSourceType SourceTypeEnum = "helmrepo"
 	// GitHubSourceType github source type
 	GitHubSourceType SourceTypeEnum = "github"
+	// OCISourceType oci registry source type
+	OCISourceType SourceTypeEnum = "oci"
 )
 
 //HelmReleaseStatus struct containing the status
@@ -57,6 +59,10 @@ type HelmReleaseStatus struct {
 	Message        string                `json:"message,omitempty"`
 	Reason         string                `json:"reason,omitempty"`
 	LastUpdateTime metav1.Time           `json:"lastUpdate"`
+	// Commit is the resolved commit of the git source the chart was deployed from
+	Commit string `json:"commit,omitempty"`
+	// Version is the version of the chart deployed by the release
+	Version string `json:"version,omitempty"`
 }
 
 //GitHub provides the parameters to access the helm-chart located in a github repo
@@ -64,11 +70,25 @@ type GitHub struct {
 	Urls      []string `json:"urls,omitempty"`
 	ChartPath string   `json:"chartPath,omitempty"`
 	Branch    string   `json:"branch,omitempty"`
+	// Tag to checkout, takes precedence over the branch
+	Tag string `json:"tag,omitempty"`
+	// Commit is the full SHA of the commit to checkout, takes precedence over the tag and the branch
+	Commit string `json:"commit,omitempty"`
 }
 
 //HelmRepo provides the urls to retrieve the helm-chart
 type HelmRepo struct {
 	Urls []string `json:"urls,omitempty"`
+	// RepoURL is the url of the helm repository serving the index of the urls, the credentials of the
+	// secret are only sent to the urls with the scheme and the host of the repository. If empty they
+	// are sent to all the urls.
+	RepoURL string `json:"repoUrl,omitempty"`
+}
+
+//OCI provides the references to retrieve the helm-chart from an OCI registry
+type OCI struct {
+	// Urls of the chart, either oci://<registry>/<repository>:<tag> or oci://<registry>/<repository>@<digest>
+	Urls []string `json:"urls,omitempty"`
 }
 
 //Source holds the different types of repository
@@ -76,6 +96,7 @@ type Source struct {
 	SourceType SourceTypeEnum `json:"type,omitempty"`
 	GitHub     *GitHub        `json:"github,omitempty"`
 	HelmRepo   *HelmRepo      `json:"helmRepo,omitempty"`
+	OCI        *OCI           `json:"oci,omitempty"`
 }
 
 func (s Source) String() string {
@@ -84,11 +105,31 @@ func (s Source) String() string {
 		return fmt.Sprintf("%v", s.HelmRepo.Urls)
 	case string(GitHubSourceType):
 		return fmt.Sprintf("%v|%s|%s", s.GitHub.Urls, s.GitHub.Branch, s.GitHub.ChartPath)
+	case string(OCISourceType):
+		return fmt.Sprintf("%v", s.OCI.Urls)
 	default:
 		return fmt.Sprintf("SourceType %s not supported", s.SourceType)
 	}
 }
 
+//VerificationMode defines how the chart is verified before the installation
+type VerificationMode string
+
+const (
+	//VerificationModeNone the chart is not verified
+	VerificationModeNone VerificationMode = "None"
+	//VerificationModeProvenance the chart must be signed, the .prov file next to the chart is verified against the keyring
+	VerificationModeProvenance VerificationMode = "Provenance"
+)
+
+//ChartVerification provides the parameters to verify the chart signature
+type ChartVerification struct {
+	// Mode None (default) or Provenance
+	Mode VerificationMode `json:"mode,omitempty"`
+	// KeyringSecretRef is the secret holding the public keyring of the signers in the keyring key
+	KeyringSecretRef *corev1.ObjectReference `json:"keyringSecretRef,omitempty"`
+}
+
 // HelmReleaseSpec defines the desired state of HelmRelease
 // +k8s:openapi-gen=true
 type HelmReleaseSpec struct {
@@ -103,12 +144,16 @@ type HelmReleaseSpec struct {
 	ReleaseName string `json:"releaseName,omitempty"`
 	// Version is the chart version
 	Version string `json:"version,omitempty"`
+	// Digest is the expected sha256 of the chart archive, the chart is not installed if it doesn't match
+	Digest string `json:"digest,omitempty"`
 	// Values is a string containing (unparsed) YAML values
 	Values string `json:"values,omitempty"`
 	// Secret to use to access the helm-repo defined in the CatalogSource.
 	SecretRef *corev1.ObjectReference `json:"secretRef,omitempty"`
 	// Configuration parameters to access the helm-repo defined in the CatalogSource
 	ConfigMapRef *corev1.ObjectReference `json:"configMapRef,omitempty"`
+	// Verification of the chart signature before the installation
+	Verification *ChartVerification `json:"verification,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -24,6 +24,27 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartVerification) DeepCopyInto(out *ChartVerification) {
+	*out = *in
+	if in.KeyringSecretRef != nil {
+		in, out := &in.KeyringSecretRef, &out.KeyringSecretRef
+		*out = new(v1.ObjectReference)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartVerification.
+func (in *ChartVerification) DeepCopy() *ChartVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitHub) DeepCopyInto(out *GitHub) {
 	*out = *in
@@ -161,6 +182,21 @@ func (in *HelmChartSubscriptionSpec) DeepCopyInto(out *HelmChartSubscriptionSpec
 		*out = new(v1.ObjectReference)
 		**out = **in
 	}
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(ChartVerification)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PollInterval != nil {
+		in, out := &in.PollInterval, &out.PollInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ApprovedUpgrades != nil {
+		in, out := &in.ApprovedUpgrades, &out.ApprovedUpgrades
+		*out = make([]PackageUpgrade, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -185,6 +221,24 @@ func (in *HelmChartSubscriptionStatus) DeepCopyInto(out *HelmChartSubscriptionSt
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.ChartSources != nil {
+		in, out := &in.ChartSources, &out.ChartSources
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NextPollTime != nil {
+		in, out := &in.NextPollTime, &out.NextPollTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PendingUpgrades != nil {
+		in, out := &in.PendingUpgrades, &out.PendingUpgrades
+		*out = make(map[string]PendingUpgrade, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 	return
 }
 
@@ -294,6 +348,11 @@ func (in *HelmReleaseSpec) DeepCopyInto(out *HelmReleaseSpec) {
 		*out = new(v1.ObjectReference)
 		**out = **in
 	}
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(ChartVerification)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -366,6 +425,48 @@ func (in *HelmRepoSubscription) DeepCopy() *HelmRepoSubscription {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCI) DeepCopyInto(out *OCI) {
+	*out = *in
+	if in.Urls != nil {
+		in, out := &in.Urls, &out.Urls
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCI.
+func (in *OCI) DeepCopy() *OCI {
+	if in == nil {
+		return nil
+	}
+	out := new(OCI)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCISubscription) DeepCopyInto(out *OCISubscription) {
+	*out = *in
+	if in.Urls != nil {
+		in, out := &in.Urls, &out.Urls
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCISubscription.
+func (in *OCISubscription) DeepCopy() *OCISubscription {
+	if in == nil {
+		return nil
+	}
+	out := new(OCISubscription)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Overrides) DeepCopyInto(out *Overrides) {
 	*out = *in
@@ -434,6 +535,39 @@ func (in *PackageOverride) DeepCopy() *PackageOverride {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageUpgrade) DeepCopyInto(out *PackageUpgrade) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageUpgrade.
+func (in *PackageUpgrade) DeepCopy() *PackageUpgrade {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageUpgrade)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingUpgrade) DeepCopyInto(out *PendingUpgrade) {
+	*out = *in
+	in.DetectionTime.DeepCopyInto(&out.DetectionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingUpgrade.
+func (in *PendingUpgrade) DeepCopy() *PendingUpgrade {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingUpgrade)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Source) DeepCopyInto(out *Source) {
 	*out = *in
@@ -447,6 +581,11 @@ func (in *Source) DeepCopyInto(out *Source) {
 		*out = new(HelmRepo)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.OCI != nil {
+		in, out := &in.OCI, &out.OCI
+		*out = new(OCI)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -473,6 +612,11 @@ func (in *SourceSubscription) DeepCopyInto(out *SourceSubscription) {
 		*out = new(HelmRepoSubscription)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.OCI != nil {
+		in, out := &in.OCI, &out.OCI
+		*out = new(OCISubscription)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
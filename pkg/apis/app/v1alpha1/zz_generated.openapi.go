@@ -153,6 +153,13 @@ func schema_pkg_apis_app_v1alpha1_HelmReleaseSpec(ref common.ReferenceCallback)
 							Format:      "",
 						},
 					},
+					"digest": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Digest is the expected sha256 of the chart archive, the chart is not installed if it doesn't match",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
 					"values": {
 						SchemaProps: spec.SchemaProps{
 							Description: "Values is a string containing (unparsed) YAML values",
@@ -172,10 +179,16 @@ func schema_pkg_apis_app_v1alpha1_HelmReleaseSpec(ref common.ReferenceCallback)
 							Ref:         ref("k8s.io/api/core/v1.ObjectReference"),
 						},
 					},
+					"verification": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Verification of the chart signature before the installation",
+							Ref:         ref("./pkg/apis/app/v1alpha1.ChartVerification"),
+						},
+					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"./pkg/apis/app/v1alpha1.Source", "k8s.io/api/core/v1.ObjectReference"},
+			"./pkg/apis/app/v1alpha1.ChartVerification", "./pkg/apis/app/v1alpha1.Source", "k8s.io/api/core/v1.ObjectReference"},
 	}
 }
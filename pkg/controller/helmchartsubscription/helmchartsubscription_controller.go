@@ -21,10 +21,13 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -37,12 +40,15 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
-	"github.com/IBM/multicloud-operators-subscription-release/pkg/helmreposubscriber"
+	"github.com/IBM/multicloud-operators-subscription-release/pkg/subscriber"
+	"github.com/IBM/multicloud-operators-subscription-release/pkg/webhook"
 )
 
 //ControllerCMDOptions possible command line options
 type ControllerCMDOptions struct {
 	Disabled bool
+	//WebhookAddr is the address of the webhook receiver, the receiver is disabled if empty
+	WebhookAddr string
 }
 
 //Options the command line options
@@ -57,16 +63,45 @@ var Options = ControllerCMDOptions{}
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
 	if !Options.Disabled {
-		return add(mgr, newReconciler(mgr))
+		r := newReconciler(mgr)
+
+		if Options.WebhookAddr != "" {
+			err := webhook.AddToManager(mgr, Options.WebhookAddr, r)
+			if err != nil {
+				return err
+			}
+		}
+
+		return add(mgr, r)
 	}
 
 	return nil
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager) *ReconcileSubscription {
 	subscriberMap := make(map[string]appv1alpha1.Subscriber)
-	return &ReconcileSubscription{client: mgr.GetClient(), scheme: mgr.GetScheme(), subscriberMap: subscriberMap}
+
+	//The subscribers are stopped when the manager shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+
+		return nil
+	}))
+	if err != nil {
+		klog.Error(err, " - Unable to cancel the subscribers on shutdown")
+	}
+
+	return &ReconcileSubscription{
+		client:          mgr.GetClient(),
+		scheme:          mgr.GetScheme(),
+		subscriberMap:   subscriberMap,
+		subscribedSpecs: make(map[string]subscribedSpec),
+		ctx:             ctx,
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -109,13 +144,26 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 // blank assignment to verify that ReconcileSubscription implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileSubscription{}
 
+//subscribedSpec identifies the spec of the subscription a subscriber was started with
+type subscribedSpec struct {
+	sourceType string
+	generation int64
+}
+
 // ReconcileSubscription reconciles a Subscription object
 type ReconcileSubscription struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client        client.Client
-	scheme        *runtime.Scheme
+	client client.Client
+	scheme *runtime.Scheme
+	//mutex protects the subscriberMap and the subscribedSpecs, they are shared with the webhook receiver.
+	//The subscription and its HelmReleases are read and updated without it.
+	mutex         sync.Mutex
 	subscriberMap map[string]appv1alpha1.Subscriber
+	//subscribedSpecs the spec each subscriber was last started with
+	subscribedSpecs map[string]subscribedSpec
+	//ctx is cancelled when the manager shuts down
+	ctx context.Context
 }
 
 // Reconcile reads that state of the cluster for a Subscription object and makes changes based on the state read
@@ -137,6 +185,10 @@ func (r *ReconcileSubscription) Reconcile(request reconcile.Request) (reconcile.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			klog.V(3).Info("Subscription deleted but request already created, cleaning subscriber")
+
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+
 			return reconcile.Result{}, r.cleanSubscriber(subkey)
 		}
 		// Error reading the object - requeue the request.
@@ -145,43 +197,125 @@ func (r *ReconcileSubscription) Reconcile(request reconcile.Request) (reconcile.
 		return reconcile.Result{}, err
 	}
 
-	subscriber := r.subscriberMap[subkey]
-	if subscriber == nil {
+	packagesChanged := r.setPackageStatus(instance)
+
+	r.mutex.Lock()
+	subscribed := r.subscriberMap[subkey] != nil && r.subscribedSpecs[subkey].generation == instance.Generation
+	r.mutex.Unlock()
+
+	//Only the HelmReleases changed, the subscriber already runs with the spec
+	if subscribed {
+		if !packagesChanged {
+			return reconcile.Result{}, nil
+		}
+
+		klog.V(3).Info("Update the package status of ", subkey)
+
+		err = r.client.Status().Update(context.TODO(), instance)
+		if err != nil {
+			klog.Error(err, " - unable to update the package status")
+
+			return reconcile.Result{
+				RequeueAfter: time.Second,
+			}, nil
+		}
+
+		return reconcile.Result{}, nil
+	}
+
+	return r.SetStatus(instance, r.startSubscriber(subkey, instance))
+}
+
+//startSubscriber starts the subscriber of the subscription or updates it with the spec,
+//the subscribers are only accessed with the mutex held.
+func (r *ReconcileSubscription) startSubscriber(subkey string, instance *appv1alpha1.HelmChartSubscription) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sourceType := ""
+	if instance.Spec.Source != nil {
+		sourceType = strings.ToLower(string(instance.Spec.Source.SourceType))
+	}
+
+	//The subscriber is replaced when the source type changes
+	if r.subscriberMap[subkey] != nil && r.subscribedSpecs[subkey].sourceType != sourceType {
+		klog.V(2).Info("Source type of ", subkey, " changed to ", sourceType, ", replacing the subscriber")
+
+		err := r.cleanSubscriber(subkey)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	sub := r.subscriberMap[subkey]
+	if sub == nil {
 		klog.V(2).Info(fmt.Sprintf("subscriber %s does not exist", instance.Name))
 
-		subscriber = &helmreposubscriber.HelmRepoSubscriber{
-			Client:                r.client,
-			Scheme:                r.scheme,
-			HelmChartSubscription: instance,
+		sub, err = subscriber.New(r.client, r.scheme, instance)
+		if err != nil {
+			klog.Error(err, " - Unable to create the subscriber of ", subkey)
+			return err
 		}
 
-		r.subscriberMap[subkey] = subscriber
-		err = subscriber.Restart()
+		r.subscriberMap[subkey] = sub
+		err = sub.Restart(r.ctx)
 	} else {
 		klog.V(2).Info("Subscriber does exist")
-		err = subscriber.Update(instance)
+		err = sub.Update(r.ctx, instance)
 	}
+
+	//A failed start is retried by the requeue
+	if err == nil {
+		r.subscribedSpecs[subkey] = subscribedSpec{sourceType: sourceType, generation: instance.Generation}
+	} else {
+		r.subscribedSpecs[subkey] = subscribedSpec{sourceType: sourceType}
+	}
+
 	//If the subscriber didn't start then clean
-	if !subscriber.IsStarted() {
+	if !sub.IsStarted() {
 		klog.V(3).Info("Subscriber didn't start")
 
-		err := r.cleanSubscriber(subkey)
-		if err != nil {
-			return r.SetStatus(instance, err)
+		cleanErr := r.cleanSubscriber(subkey)
+		if cleanErr != nil {
+			return cleanErr
+		}
+	}
+
+	return err
+}
+
+//TriggerSync triggers the sync of the started subscribers matching the repository urls and the branch,
+//it returns the number of subscribers triggered.
+func (r *ReconcileSubscription) TriggerSync(urls []string, branch string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	triggered := 0
+
+	for subkey, sub := range r.subscriberMap {
+		trigger, ok := sub.(appv1alpha1.SyncTrigger)
+		if ok && trigger.TriggerSync(urls, branch) {
+			klog.V(2).Info("Sync triggered for ", subkey)
+
+			triggered++
 		}
 	}
 
-	return r.SetStatus(instance, err)
+	return triggered
 }
 
+//cleanSubscriber stops the subscriber and forgets it, the mutex must be held
 func (r *ReconcileSubscription) cleanSubscriber(subkey string) error {
-	subscriber := r.subscriberMap[subkey]
-	if subscriber != nil {
+	sub := r.subscriberMap[subkey]
+	if sub != nil {
 		klog.V(3).Info("Cleaning subscriber map and stopping subscriber")
 
-		err := subscriber.Stop()
+		err := sub.Stop()
 
 		delete(r.subscriberMap, subkey)
+		delete(r.subscribedSpecs, subkey)
 
 		return err
 	}
@@ -189,6 +323,51 @@ func (r *ReconcileSubscription) cleanSubscriber(subkey string) error {
 	return nil
 }
 
+//setPackageStatus sets the status of the HelmReleases owned by the subscription per package,
+//it returns true if the package status changed
+func (r *ReconcileSubscription) setPackageStatus(instance *appv1alpha1.HelmChartSubscription) bool {
+	helmReleaseList := &appv1alpha1.HelmReleaseList{}
+
+	//Only the HelmReleases labeled by the subscriber of this subscription are listed
+	err := r.client.List(context.TODO(), helmReleaseList, &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labels.SelectorFromSet(labels.Set{appv1alpha1.HelmChartSubscriptionUIDLabel: string(instance.UID)}),
+	})
+	if err != nil {
+		klog.Error(err, " - Unable to list the HelmReleases of ", instance.Namespace, "/", instance.Name)
+		return false
+	}
+
+	var packageStatus map[string]appv1alpha1.HelmChartSubscriptionUnitStatus
+
+	for i := range helmReleaseList.Items {
+		hr := &helmReleaseList.Items[i]
+		if !metav1.IsControlledBy(hr, instance) {
+			continue
+		}
+
+		if packageStatus == nil {
+			packageStatus = make(map[string]appv1alpha1.HelmChartSubscriptionUnitStatus)
+		}
+
+		packageStatus[hr.Spec.ChartName] = appv1alpha1.HelmChartSubscriptionUnitStatus{
+			Status:         appv1alpha1.HelmChartSubscriptionStatusEnum(hr.Status.Status),
+			Message:        hr.Status.Message,
+			Reason:         hr.Status.Reason,
+			LastUpdateTime: hr.Status.LastUpdateTime,
+			Version:        hr.Status.Version,
+		}
+	}
+
+	if reflect.DeepEqual(instance.Status.HelmChartSubscriptionPackageStatus, packageStatus) {
+		return false
+	}
+
+	instance.Status.HelmChartSubscriptionPackageStatus = packageStatus
+
+	return true
+}
+
 //SetStatus set the subscription status
 func (r *ReconcileSubscription) SetStatus(s *appv1alpha1.HelmChartSubscription, issue error) (reconcile.Result, error) {
 	//Success
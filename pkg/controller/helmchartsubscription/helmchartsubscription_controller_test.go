@@ -21,6 +21,7 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/onsi/gomega"
 	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -106,19 +107,57 @@ func TestReconcileHelmRepoSuccess(t *testing.T) {
 
 	g.Eventually(requests, timeout).Should(gomega.Receive(gomega.Equal(expectedRequest)))
 
-	time.Sleep(5 * time.Second)
+	//In Manual mode the HelmRelease is installed once without approval
+	helmReleaseList := &appv1alpha1.HelmReleaseList{}
+
+	g.Eventually(func() int {
+		err := c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
+		if err != nil {
+			return 0
+		}
+
+		return len(helmReleaseList.Items)
+	}, 2*timeout).Should(gomega.Equal(1))
 
 	instanceResp := &appv1alpha1.HelmChartSubscription{}
-	err = c.Get(context.TODO(), helmChartSubscriptionKey, instanceResp)
-	g.Expect(err).NotTo(gomega.HaveOccurred())
 
-	g.Expect(instanceResp.Status.Status).To(gomega.Equal(appv1alpha1.HelmChartSubscriptionSuccess))
+	g.Eventually(func() appv1alpha1.HelmChartSubscriptionStatusEnum {
+		err := c.Get(context.TODO(), helmChartSubscriptionKey, instanceResp)
+		if err != nil {
+			return ""
+		}
 
-	helmReleaseList := &appv1alpha1.HelmReleaseList{}
-	err = c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
-	g.Expect(err).NotTo(gomega.HaveOccurred())
+		return instanceResp.Status.Status
+	}, timeout).Should(gomega.Equal(appv1alpha1.HelmChartSubscriptionSuccess))
+
+	g.Expect(instanceResp.Status.PendingUpgrades).To(gomega.BeEmpty())
+
+	hr := &helmReleaseList.Items[0]
+	g.Expect(hr.Labels[appv1alpha1.HelmChartSubscriptionUIDLabel]).To(gomega.Equal(string(instanceResp.UID)))
+
+	//The HelmRelease controller doesn't run in this test, the deployed version is set as it would
+	g.Eventually(func() error {
+		err := c.Get(context.TODO(), types.NamespacedName{Name: hr.Name, Namespace: hr.Namespace}, hr)
+		if err != nil {
+			return err
+		}
+
+		hr.Status.Status = appv1alpha1.HelmReleaseSuccess
+		hr.Status.Version = "0.2.0"
+		hr.Status.LastUpdateTime = metav1.Now()
+
+		return c.Status().Update(context.TODO(), hr)
+	}, timeout).Should(gomega.Succeed())
+
+	//The owned HelmRelease is reported per package
+	g.Eventually(func() string {
+		err := c.Get(context.TODO(), helmChartSubscriptionKey, instanceResp)
+		if err != nil {
+			return ""
+		}
 
-	g.Expect(1).To(gomega.Equal(len(helmReleaseList.Items)))
+		return instanceResp.Status.HelmChartSubscriptionPackageStatus["subscription-release-test-1"].Version
+	}, timeout).Should(gomega.Equal("0.2.0"))
 
 	for _, hr := range helmReleaseList.Items {
 		err = c.Delete(context.TODO(), &hr)
@@ -20,14 +20,13 @@ package helmrelease
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"os"
 	"reflect"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rpb "k8s.io/helm/pkg/proto/hapi/release"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -54,22 +53,28 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileHelmRelease{mgr}
+	//The downloads in progress are cancelled when the manager shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+
+		return nil
+	}))
+	if err != nil {
+		klog.Error(err, " - Unable to cancel the downloads on shutdown")
+	}
+
+	return &ReconcileHelmRelease{Manager: mgr, ctx: ctx}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	chartsDir := os.Getenv(appv1alpha1.ChartsDir)
-	if chartsDir == "" {
-		chartsDir, err := ioutil.TempDir("/tmp", "charts")
-		if err != nil {
-			return err
-		}
-
-		err = os.Setenv(appv1alpha1.ChartsDir, chartsDir)
-		if err != nil {
-			return err
-		}
+	//The charts directory is shared by the downloads and the cleanup
+	_, err := utils.GetChartsDir()
+	if err != nil {
+		return err
 	}
 
 	// Create a new controller
@@ -113,6 +118,8 @@ type ReconcileHelmRelease struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
 	manager.Manager
+	//ctx is cancelled when the manager shuts down
+	ctx context.Context
 }
 
 // Reconcile reads that state of the cluster for a HelmRelease object and makes changes based on the state read
@@ -144,7 +151,7 @@ func (r *ReconcileHelmRelease) Reconcile(request reconcile.Request) (reconcile.R
 	}
 
 	// Define a new Pod object
-	err = r.manageHelmRelease(instance)
+	err = r.manageHelmRelease(r.ctx, instance)
 
 	//if the instance was set for deletion and the finalizer already remove then
 	//no need to update the status
@@ -155,12 +162,12 @@ func (r *ReconcileHelmRelease) Reconcile(request reconcile.Request) (reconcile.R
 	return r.SetStatus(instance, err)
 }
 
-func (r *ReconcileHelmRelease) manageHelmRelease(sr *appv1alpha1.HelmRelease) error {
+func (r *ReconcileHelmRelease) manageHelmRelease(ctx context.Context, sr *appv1alpha1.HelmRelease) error {
 	klog.V(3).Info("chart: ", sr.Spec.ChartName, " release:", sr.Spec.ReleaseName, "annotations:", sr.GetAnnotations())
 
 	klog.V(5).Info("Create Manager")
 
-	helmReleaseManager, err := r.newHelmReleaseManager(sr)
+	helmReleaseManager, err := r.newHelmReleaseManager(ctx, sr)
 
 	if err != nil {
 		klog.Error(err, "- Failed to create NewManager ", sr.Spec.ChartName)
@@ -176,10 +183,12 @@ func (r *ReconcileHelmRelease) manageHelmRelease(sr *appv1alpha1.HelmRelease) er
 	}
 
 	if sr.DeletionTimestamp == nil {
+		var release *rpb.Release
+
 		if helmReleaseManager.IsInstalled() {
 			klog.Info("Update chart ", sr.Spec.ChartName)
 
-			_, _, err = helmReleaseManager.UpdateRelease(context.TODO())
+			_, release, err = helmReleaseManager.UpdateRelease(context.TODO())
 			if err != nil {
 				klog.Error(err, " - Failed to while update chart: ", sr.Spec.ChartName)
 				return err
@@ -187,12 +196,15 @@ func (r *ReconcileHelmRelease) manageHelmRelease(sr *appv1alpha1.HelmRelease) er
 		} else {
 			klog.Info("Install chart: ", sr.Spec.ChartName)
 
-			_, err = helmReleaseManager.InstallRelease(context.TODO())
+			release, err = helmReleaseManager.InstallRelease(context.TODO())
 			if err != nil {
 				klog.Error(err, " - Failed to while install chart: ", sr.Spec.ChartName)
 				return err
 			}
 		}
+
+		//The version deployed, as reported by the release
+		sr.Status.Version = release.GetChart().GetMetadata().GetVersion()
 	} else {
 		klog.Info("Delete chart: ", sr.Spec.ChartName)
 		if helmReleaseManager.IsInstalled() {
@@ -201,6 +213,11 @@ func (r *ReconcileHelmRelease) manageHelmRelease(sr *appv1alpha1.HelmRelease) er
 				klog.Error(err, " - Failed to while un-install chart: ", sr.Spec.ChartName)
 			}
 		}
+		err = utils.DeleteChartsDir("", sr)
+		if err != nil {
+			klog.Error(err, " - Failed to delete the charts of: ", sr.Spec.ChartName)
+		}
+
 		klog.Info("Remove finalizer from helmrelease : ", sr.Namespace, "/", sr.Name)
 		utils.RemoveFinalizer(sr)
 	}
@@ -56,7 +56,7 @@ func TestReconcile(t *testing.T) {
 	c := mgr.GetClient()
 
 	rec := &ReconcileHelmRelease{
-		mgr,
+		Manager: mgr,
 	}
 
 	t.Log("Setup test reconcile")
@@ -112,6 +112,7 @@ func TestReconcile(t *testing.T) {
 
 	t.Logf("Reason: %s", instanceResp.Status.Reason)
 	g.Expect(instanceResp.Status.Status).To(gomega.Equal(appv1alpha1.HelmReleaseSuccess))
+	g.Expect(instanceResp.Status.Version).To(gomega.Equal("0.1.0"))
 
 	//
 	//Github failed
@@ -433,7 +434,7 @@ func TestReconcile(t *testing.T) {
 
 	time.Sleep(6 * time.Second)
 
-	_, err = rec.newHelmReleaseManager(instance)
+	_, err = rec.newHelmReleaseManager(context.TODO(), instance)
 	assert.NoError(t, err)
 
 	// TestNewManagerShortReleaseName
@@ -461,7 +462,7 @@ func TestReconcile(t *testing.T) {
 
 	time.Sleep(6 * time.Second)
 
-	_, err = rec.newHelmReleaseManager(instance)
+	_, err = rec.newHelmReleaseManager(context.TODO(), instance)
 	assert.NoError(t, err)
 
 	// TestNewManagerValues
@@ -491,11 +492,11 @@ func TestReconcile(t *testing.T) {
 	time.Sleep(6 * time.Second)
 
 	//Values well formed
-	_, err = rec.newHelmReleaseManager(instance)
+	_, err = rec.newHelmReleaseManager(context.TODO(), instance)
 	assert.NoError(t, err)
 	//Values not a yaml
 	instance.Spec.Values = "l1:\nl2"
-	_, err = rec.newHelmReleaseManager(instance)
+	_, err = rec.newHelmReleaseManager(context.TODO(), instance)
 	assert.Error(t, err)
 
 	// TestNewManagerErrors
@@ -527,7 +528,7 @@ func TestReconcile(t *testing.T) {
 	//Download Chart should fail
 	instance.Spec.Source.GitHub.Urls[0] = "wrongurl"
 	instance.Spec.Values = "l1:\nl2"
-	_, err = rec.newHelmReleaseManager(instance)
+	_, err = rec.newHelmReleaseManager(context.TODO(), instance)
 	assert.Error(t, err)
 
 	// TestNewManagerForDeletion
@@ -565,7 +566,7 @@ func TestReconcile(t *testing.T) {
 	time.Sleep(6 * time.Second)
 
 	instance.GetObjectMeta().SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
-	mgrhr, err := rec.newHelmReleaseManager(instance)
+	mgrhr, err := rec.newHelmReleaseManager(context.TODO(), instance)
 	assert.NoError(t, err)
 
 	assert.Equal(t, mgrhr.ReleaseName(), helmReleaseName)
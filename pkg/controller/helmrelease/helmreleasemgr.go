@@ -19,8 +19,6 @@ package helmrelease
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"os"
 
 	"github.com/ghodss/yaml"
 	helmrelease "github.com/operator-framework/operator-sdk/pkg/helm/release"
@@ -36,10 +34,12 @@ import (
 	"github.com/IBM/multicloud-operators-subscription-release/pkg/utils"
 )
 
-//newHelmReleaseManager create a new manager returns a helmManager and the new created secret
+//newHelmReleaseManager create a new manager returns a helmManager and the new created secret,
+//the chart download is cancelled with the ctx
 func (r *ReconcileHelmRelease) newHelmReleaseManager(
+	ctx context.Context,
 	s *appv1alpha1.HelmRelease) (helmrelease.Manager, error) {
-	helmReleaseSecret, err := utils.GetSecret(r.GetClient(),
+	helmReleaseSecret, err := utils.GetSecret(ctx, r.GetClient(),
 		s.Namespace,
 		&corev1.ObjectReference{Name: s.Spec.ReleaseName})
 	if err == nil {
@@ -49,7 +49,7 @@ func (r *ReconcileHelmRelease) newHelmReleaseManager(
 					s.Spec.ReleaseName, helmReleaseSecret.GetOwnerReferences())
 		}
 	} else if errors.IsNotFound(err) {
-		helmReleaseSecret, err = createSecret(r, s)
+		helmReleaseSecret, err = createSecret(ctx, r, s)
 		if err != nil {
 			klog.Error(err)
 			return nil, err
@@ -58,13 +58,13 @@ func (r *ReconcileHelmRelease) newHelmReleaseManager(
 		return nil, err
 	}
 
-	configMap, err := utils.GetConfigMap(r.GetClient(), s.Namespace, s.Spec.ConfigMapRef)
+	configMap, err := utils.GetConfigMap(ctx, r.GetClient(), s.Namespace, s.Spec.ConfigMapRef)
 	if err != nil {
 		klog.Error(err)
 		return nil, err
 	}
 
-	secret, err := utils.GetSecret(r.GetClient(), s.Namespace, s.Spec.SecretRef)
+	secret, err := utils.GetSecret(ctx, r.GetClient(), s.Namespace, s.Spec.SecretRef)
 	if err != nil {
 		klog.Error(err, " - Failed to retrieve secret ", s.Spec.SecretRef.Name)
 		return nil, err
@@ -82,16 +82,22 @@ func (r *ReconcileHelmRelease) newHelmReleaseManager(
 	o.SetUID(helmReleaseSecret.GetUID())
 	klog.V(5).Info("uuid:", o.GetUID())
 
-	chartsDir := os.Getenv(appv1alpha1.ChartsDir)
-	if chartsDir == "" {
-		chartsDir, err = ioutil.TempDir("/tmp", "charts")
+	chartsDir, err := utils.GetChartsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var keyringSecret *corev1.Secret
+
+	if utils.IsProvenanceVerificationRequired(s.Spec.Verification) {
+		keyringSecret, err = utils.GetSecret(ctx, r.GetClient(), s.Namespace, s.Spec.Verification.KeyringSecretRef)
 		if err != nil {
-			klog.Error(err, " - Can not create tempdir")
+			klog.Error(err, " - Failed to retrieve the keyring secret")
 			return nil, err
 		}
 	}
 
-	chartDir, err := utils.DownloadChart(configMap, secret, chartsDir, s)
+	chartDir, err := utils.DownloadChart(ctx, configMap, secret, keyringSecret, chartsDir, s)
 	klog.V(3).Info("ChartDir: ", chartDir)
 
 	if s.DeletionTimestamp == nil {
@@ -130,6 +136,7 @@ func (r *ReconcileHelmRelease) newHelmReleaseManager(
 }
 
 func createSecret(
+	ctx context.Context,
 	r *ReconcileHelmRelease,
 	s *appv1alpha1.HelmRelease) (*corev1.Secret, error) {
 	var err error
@@ -152,7 +159,7 @@ func createSecret(
 		klog.Error("Failed to set owner reference for helmrelease:", s)
 	}
 
-	err = r.GetClient().Create(context.TODO(), relsec)
+	err = r.GetClient().Create(ctx, relsec)
 	if err != nil {
 		klog.Error(err)
 		return nil, err
@@ -18,11 +18,10 @@ package helmreposubscriber
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/url"
-	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -50,12 +49,48 @@ type HelmRepoSubscriber struct {
 	HelmRepoHash          string
 	HelmChartSubscription *appv1alpha1.HelmChartSubscription
 	started               bool
-	stopCh                chan struct{}
+	cancel                context.CancelFunc
+	gitRemoteHash         string
+	repoChartSources      map[string]string
+	chartSources          map[string]string
+	nextPollTime          *metav1.Time
+	nextPollTimeReported  bool
+	syncCh                chan struct{}
+	done                  chan struct{}
+	pendingUpgrades       map[string]appv1alpha1.PendingUpgrade
 }
 
-var (
-	subscriptionPeriod = 10 * time.Second
-)
+//SubscriberCMDOptions possible command line options
+type SubscriberCMDOptions struct {
+	//DefaultPollInterval is used by the subscriptions not setting a pollInterval
+	DefaultPollInterval time.Duration
+	//MinPollInterval is the lowest pollInterval a subscription can set
+	MinPollInterval time.Duration
+}
+
+//Options the command line options
+var Options = SubscriberCMDOptions{
+	DefaultPollInterval: 10 * time.Second,
+	MinPollInterval:     5 * time.Second,
+}
+
+//Validate checks the minimum interval is positive and the default interval is not lower than the minimum
+func (o SubscriberCMDOptions) Validate() error {
+	if o.MinPollInterval <= 0 {
+		return fmt.Errorf("--min-poll-interval %v must be positive", o.MinPollInterval)
+	}
+
+	if o.DefaultPollInterval < o.MinPollInterval {
+		return fmt.Errorf("--default-poll-interval %v must be greater than or equal to --min-poll-interval %v",
+			o.DefaultPollInterval, o.MinPollInterval)
+	}
+
+	return nil
+}
+
+//pollJitterFactor each poll is delayed by up to this fraction of the interval,
+//so the subscriptions started together don't hit the repositories in lockstep.
+const pollJitterFactor = 0.2
 
 //DeploymentProcessHelmOperator value to use operator instead of bitnami as deployment tool
 const DeploymentProcessHelmOperator = "helm-operator"
@@ -63,8 +98,9 @@ const DeploymentProcessHelmOperator = "helm-operator"
 //DeploymentProcessBitnami value to use bitnami as deployment tool
 const DeploymentProcessBitnami = "bitnami"
 
-// Restart a helm repo subscriber
-func (s *HelmRepoSubscriber) Restart() error {
+// Restart a helm repo subscriber, the monitoring stops when the context is cancelled.
+// In Manual mode the new versions are recorded as pending upgrades until they are approved.
+func (s *HelmRepoSubscriber) Restart(ctx context.Context) error {
 	klog.V(5).Info("Restart Subscriber")
 
 	if s.started {
@@ -75,39 +111,158 @@ func (s *HelmRepoSubscriber) Restart() error {
 	}
 
 	s.HelmRepoHash = ""
+	s.gitRemoteHash = ""
+	//The interval may have changed with the spec
+	s.nextPollTimeReported = false
 
-	approval := strings.ToLower(string(s.HelmChartSubscription.Spec.InstallPlanApproval))
-	klog.V(5).Info("Check start helm-repo monitoring",
-		"s.HelmChartSubscription.Spec.InstallPlanApproval", s.HelmChartSubscription.Spec.InstallPlanApproval)
+	klog.V(5).Info("Start helm-repo monitoring, InstallPlanApproval: ", s.HelmChartSubscription.Spec.InstallPlanApproval)
 
-	if approval != "" && strings.EqualFold(approval, string(appv1alpha1.ApprovalAutomatic)) {
-		klog.V(5).Info("Start helm-repo monitoring")
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.syncCh = make(chan struct{}, 1)
+	s.done = make(chan struct{})
 
-		s.stopCh = make(chan struct{})
+	go s.poll(ctx, PollInterval(s.HelmChartSubscription), s.syncCh, s.done)
 
-		go wait.Until(func() {
-			err := s.doHelmChartSubscription()
-			if err != nil {
-				klog.Error(err, " - Error while managing the helmChartSubscription")
-			}
-		}, subscriptionPeriod, s.stopCh)
+	s.started = true
+
+	return nil
+}
+
+//poll monitors the source every interval, jittered, and when a sync is triggered until the context is cancelled,
+//done is closed when the monitoring is over.
+func (s *HelmRepoSubscriber) poll(ctx context.Context, interval time.Duration, syncCh <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	//The first pass is jittered too, the subscriptions restarted together by the operator start apart
+	select {
+	case <-ctx.Done():
+		klog.V(3).Info("HelmChartSubscription monitoring stopped: ", ctx.Err())
+		return
+	case <-syncCh:
+	case <-time.After(wait.Jitter(interval, pollJitterFactor) - interval):
+	}
+
+	for {
+		err := s.doHelmChartSubscription(ctx)
+		if ctx.Err() != nil {
+			klog.V(3).Info("HelmChartSubscription monitoring stopped: ", ctx.Err())
+			return
+		}
 
-		s.started = true
-	} else {
-		s.started = false
-		err := s.doHelmChartSubscription()
 		if err != nil {
-			return err
+			klog.Error(err, " - Error while managing the helmChartSubscription")
+		}
+
+		delay := wait.Jitter(interval, pollJitterFactor)
+		nextPollTime := metav1.NewTime(time.Now().Add(delay))
+		s.nextPollTime = &nextPollTime
+
+		err = s.updateStatus(ctx, err)
+		if err != nil {
+			klog.Error(err, " - Unable to update the helmChartSubscription status")
 		}
+
+		select {
+		case <-ctx.Done():
+			klog.V(3).Info("HelmChartSubscription monitoring stopped: ", ctx.Err())
+			return
+		case <-syncCh:
+			klog.Info("Sync triggered for ", s.HelmChartSubscription.Namespace, "/", s.HelmChartSubscription.Name)
+		case <-time.After(delay):
+		}
+	}
+}
+
+//TriggerSync checks the source now if the subscriber is started and its source matches
+func (s *HelmRepoSubscriber) TriggerSync(urls []string, branch string) bool {
+	if !s.started || !s.matchesSource(urls, branch) {
+		return false
 	}
 
-	return nil
+	//A pending sync covers the new one
+	select {
+	case s.syncCh <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+//matchesSource returns true if one of the urls is a url of the source, the branch only applies
+//to the git repositories following a branch and an empty branch matches all the branches.
+func (s *HelmRepoSubscriber) matchesSource(urls []string, branch string) bool {
+	source := s.HelmChartSubscription.Spec.Source
+	if source == nil {
+		return false
+	}
+
+	var sourceURLs []string
+
+	switch strings.ToLower(string(source.SourceType)) {
+	case string(appv1alpha1.HelmRepoSourceType):
+		if source.HelmRepo != nil {
+			sourceURLs = source.HelmRepo.Urls
+		}
+	case string(appv1alpha1.GitHubSourceType):
+		if source.GitHub == nil {
+			return false
+		}
+
+		if branch != "" {
+			//A tag or a commit doesn't move with the branch
+			if source.GitHub.Tag != "" || source.GitHub.Commit != "" {
+				return false
+			}
+
+			sourceBranch := source.GitHub.Branch
+			if sourceBranch == "" {
+				sourceBranch = "master"
+			}
+
+			if sourceBranch != branch {
+				return false
+			}
+		}
+
+		sourceURLs = source.GitHub.Urls
+	case string(appv1alpha1.OCISourceType):
+		if source.OCI != nil {
+			sourceURLs = source.OCI.Urls
+		}
+	}
+
+	for _, sourceURL := range sourceURLs {
+		for _, url := range urls {
+			if utils.SameRepositoryURL(sourceURL, url) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
-// Stop a helm repo subscriber
+//PollInterval returns the pollInterval of the subscription, the default interval when not set
+//and the minimum interval when lower than it.
+func PollInterval(sub *appv1alpha1.HelmChartSubscription) time.Duration {
+	if sub.Spec.PollInterval == nil {
+		return Options.DefaultPollInterval
+	}
+
+	if sub.Spec.PollInterval.Duration < Options.MinPollInterval {
+		klog.Info("pollInterval ", sub.Spec.PollInterval.Duration, " of ", sub.Namespace, "/", sub.Name,
+			" is lower than the minimum, using ", Options.MinPollInterval)
+		return Options.MinPollInterval
+	}
+
+	return sub.Spec.PollInterval.Duration
+}
+
+// Stop a helm repo subscriber, an in-flight monitoring pass is cancelled and Stop returns once it is over
 func (s *HelmRepoSubscriber) Stop() error {
 	if s.started {
-		close(s.stopCh)
+		s.cancel()
+		<-s.done
 	}
 
 	s.started = false
@@ -115,19 +270,17 @@ func (s *HelmRepoSubscriber) Stop() error {
 	return nil
 }
 
-// Update a namespace subscriber
-func (s *HelmRepoSubscriber) Update(sub *appv1alpha1.HelmChartSubscription) error {
-	s.HelmChartSubscription = sub
-	approval := strings.ToLower(string(s.HelmChartSubscription.Spec.InstallPlanApproval))
-
-	klog.V(5).Info("InstallPlanApproval: ", approval)
-	klog.V(5).Info("ApprovalManual :", strings.ToLower(string(appv1alpha1.ApprovalManual)))
-
-	if approval == "" || strings.EqualFold(string(s.HelmChartSubscription.Spec.InstallPlanApproval), string(appv1alpha1.ApprovalManual)) {
-		return s.Stop()
+// Update a namespace subscriber, the approved upgrades are applied by the restart
+func (s *HelmRepoSubscriber) Update(ctx context.Context, sub *appv1alpha1.HelmChartSubscription) error {
+	//The monitoring reads the subscription, it is replaced once the monitoring is stopped
+	err := s.Stop()
+	if err != nil {
+		return err
 	}
 
-	return s.Restart()
+	s.HelmChartSubscription = sub
+
+	return s.Restart(ctx)
 }
 
 //IsStarted is true if subscriber started
@@ -135,7 +288,7 @@ func (s *HelmRepoSubscriber) IsStarted() bool {
 	return s.started
 }
 
-func (s *HelmRepoSubscriber) doHelmChartSubscription() error {
+func (s *HelmRepoSubscriber) doHelmChartSubscription(ctx context.Context) error {
 	var indexFile *repo.IndexFile
 
 	var hash, url string
@@ -144,11 +297,14 @@ func (s *HelmRepoSubscriber) doHelmChartSubscription() error {
 
 	switch strings.ToLower(string(s.HelmChartSubscription.Spec.Source.SourceType)) {
 	case string(appv1alpha1.HelmRepoSourceType):
-		indexFile, hash, err = s.getHelmRepoIndexFile()
+		indexFile, hash, err = s.getHelmRepoIndexFile(ctx)
 		url = fmt.Sprintf("%v", s.HelmChartSubscription.Spec.Source.HelmRepo.Urls)
 	case string(appv1alpha1.GitHubSourceType):
-		indexFile, hash, err = s.generateGitHubIndexFile()
+		indexFile, hash, err = s.generateGitHubIndexFile(ctx)
 		url = fmt.Sprintf("%v", s.HelmChartSubscription.Spec.Source.GitHub.Urls)
+	case string(appv1alpha1.OCISourceType):
+		indexFile, hash, err = s.getOCIIndexFile(ctx)
+		url = fmt.Sprintf("%v", s.HelmChartSubscription.Spec.Source.OCI.Urls)
 	default:
 		err = fmt.Errorf("sourceType '%s' unsupported", s.HelmChartSubscription.Spec.Source.SourceType)
 	}
@@ -165,7 +321,7 @@ func (s *HelmRepoSubscriber) doHelmChartSubscription() error {
 
 		s.HelmRepoHash = hash
 
-		err = s.processHelmChartSubscription(indexFile)
+		err = s.processHelmChartSubscription(ctx, indexFile)
 		if err != nil {
 			klog.Error(err, " - Error processing subscription")
 			return err
@@ -177,30 +333,111 @@ func (s *HelmRepoSubscriber) doHelmChartSubscription() error {
 	return nil
 }
 
+//updateStatus reports the result of a monitoring pass in the helmChartSubscription status,
+//the status is only updated when it changes. The next poll time is only reported with the first
+//pass after a restart, when the interval may change, or with a change of the status.
+func (s *HelmRepoSubscriber) updateStatus(ctx context.Context, issue error) error {
+	instance := &appv1alpha1.HelmChartSubscription{}
+
+	err := s.Client.Get(ctx,
+		types.NamespacedName{Name: s.HelmChartSubscription.Name, Namespace: s.HelmChartSubscription.Namespace},
+		instance)
+	if err != nil {
+		return err
+	}
+
+	status := appv1alpha1.HelmChartSubscriptionSuccess
+	message := ""
+	reason := ""
+
+	if issue != nil {
+		status = appv1alpha1.HelmChartSubscriptionFailed
+		message = "Error, retrying later"
+		reason = issue.Error()
+	}
+
+	//An upgrade still pending keeps the time it was detected
+	for name, pendingUpgrade := range s.pendingUpgrades {
+		previous, ok := instance.Status.PendingUpgrades[name]
+		if ok && previous.Version == pendingUpgrade.Version && previous.CurrentVersion == pendingUpgrade.CurrentVersion &&
+			previous.SpecHash == pendingUpgrade.SpecHash {
+			pendingUpgrade.DetectionTime = previous.DetectionTime
+			s.pendingUpgrades[name] = pendingUpgrade
+		}
+	}
+
+	//The lastUpdateTime only changes with the result of the pass
+	if instance.Status.Status != status || instance.Status.Reason != reason ||
+		!reflect.DeepEqual(instance.Status.ChartSources, s.chartSources) ||
+		!reflect.DeepEqual(instance.Status.PendingUpgrades, s.pendingUpgrades) {
+		instance.Status.Status = status
+		instance.Status.Message = message
+		instance.Status.Reason = reason
+		instance.Status.ChartSources = s.chartSources
+		instance.Status.PendingUpgrades = s.pendingUpgrades
+		instance.Status.LastUpdateTime = metav1.Now()
+	} else if s.nextPollTimeReported {
+		return nil
+	}
+
+	instance.Status.NextPollTime = s.nextPollTime
+
+	err = s.Client.Status().Update(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	s.nextPollTimeReported = true
+
+	return nil
+}
+
+//setChartSources keeps the url which served each chart remaining after the filtering
+func (s *HelmRepoSubscriber) setChartSources(indexFile *repo.IndexFile) {
+	s.chartSources = nil
+
+	for name, chartVersions := range indexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			if source, ok := s.repoChartSources[utils.ChartSourceKey(chartVersion)]; ok {
+				if s.chartSources == nil {
+					s.chartSources = make(map[string]string)
+				}
+
+				s.chartSources[name] = source
+			}
+		}
+	}
+}
+
 // do a helm repo subscriber
-func (s *HelmRepoSubscriber) processHelmChartSubscription(indexFile *repo.IndexFile) error {
+func (s *HelmRepoSubscriber) processHelmChartSubscription(ctx context.Context, indexFile *repo.IndexFile) error {
 	err := s.filterCharts(indexFile)
 	if err != nil {
 		klog.Error(err, " - Unable to filter ")
 		return err
 	}
 
-	return s.manageHelmChartSubscription(indexFile)
+	s.setChartSources(indexFile)
+
+	return s.manageHelmChartSubscription(ctx, indexFile)
 }
 
 //getHelmRepoIndex retrieves the index.yaml, loads it into a repo.IndexFile and filters it
-func (s *HelmRepoSubscriber) getHelmRepoIndexFile() (indexFile *repo.IndexFile, hash string, err error) {
-	configMap, err := utils.GetConfigMap(s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.ConfigMapRef)
+func (s *HelmRepoSubscriber) getHelmRepoIndexFile(ctx context.Context) (indexFile *repo.IndexFile, hash string, err error) {
+	configMap, err := utils.GetConfigMap(ctx, s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.ConfigMapRef)
 	if err != nil {
 		klog.Error(err, " - Failed to retrieve configMap ", s.HelmChartSubscription.Spec.ConfigMapRef.Name)
 	}
 
-	secret, err := utils.GetSecret(s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.SecretRef)
+	secret, err := utils.GetSecret(ctx, s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.SecretRef)
 	if err != nil {
 		klog.Error(err, " - Failed to retrieve secret ", s.HelmChartSubscription.Spec.SecretRef.Name)
 	}
 
-	indexFile, hash, err = utils.GetHelmRepoIndex(configMap, secret, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.Source.HelmRepo.Urls)
+	helmRepo := s.HelmChartSubscription.Spec.Source.HelmRepo
+
+	indexFile, hash, s.repoChartSources, err = utils.GetHelmRepoPackageIndex(ctx, configMap, secret, s.HelmChartSubscription.Namespace,
+		helmRepo.Urls, helmRepo.Mode, s.HelmChartSubscription.Spec.Package)
 	if err != nil {
 		klog.Error(err, " - Failed to get the index.yaml")
 		return nil, "", err
@@ -209,42 +446,92 @@ func (s *HelmRepoSubscriber) getHelmRepoIndexFile() (indexFile *repo.IndexFile,
 	return indexFile, hash, err
 }
 
-func (s *HelmRepoSubscriber) generateGitHubIndexFile() (*repo.IndexFile, string, error) {
-	configMap, err := utils.GetConfigMap(s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.ConfigMapRef)
+//getOCIIndexFile lists the tags of the OCI repositories and loads them into a repo.IndexFile,
+//the hash changes with the digests of the filtered charts
+func (s *HelmRepoSubscriber) getOCIIndexFile(ctx context.Context) (indexFile *repo.IndexFile, hash string, err error) {
+	configMap, err := utils.GetConfigMap(ctx, s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.ConfigMapRef)
 	if err != nil {
 		return nil, "", err
 	}
 
-	secret, err := utils.GetSecret(s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.SecretRef)
+	secret, err := utils.GetSecret(ctx, s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.SecretRef)
 	if err != nil {
-		klog.Error(err, "Failed to retrieve secret ", s.HelmChartSubscription.Spec.SecretRef.Name)
+		klog.Error(err, " - Failed to retrieve secret ", s.HelmChartSubscription.Spec.SecretRef.Name)
 		return nil, "", err
 	}
 
-	chartsDir := os.Getenv(appv1alpha1.ChartsDir)
-	if chartsDir == "" {
-		chartsDir, err = ioutil.TempDir("/tmp", "charts")
-		if err != nil {
-			klog.Error(err, " - Can not create tempdir")
-			return nil, "", err
-		}
+	indexFile, err = utils.GetOCIIndex(ctx, configMap, secret, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.Source.OCI.Urls)
+	if err != nil {
+		klog.Error(err, " - Failed to list the oci tags")
+		return nil, "", err
 	}
 
-	destRepo := filepath.Join(chartsDir, s.HelmChartSubscription.Name, s.HelmChartSubscription.Namespace)
+	//Only the digests of the charts kept by the filter are resolved
+	err = s.filterCharts(indexFile)
+	if err != nil {
+		klog.Error(err, " - Unable to filter ")
+		return nil, "", err
+	}
+
+	hash, err = utils.ResolveOCIDigests(ctx, configMap, secret, indexFile)
+	if err != nil {
+		klog.Error(err, " - Failed to resolve the oci digests")
+		return nil, "", err
+	}
+
+	return indexFile, hash, nil
+}
+
+func (s *HelmRepoSubscriber) generateGitHubIndexFile(ctx context.Context) (*repo.IndexFile, string, error) {
+	configMap, err := utils.GetConfigMap(ctx, s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.ConfigMapRef)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := utils.GetSecret(ctx, s.Client, s.HelmChartSubscription.Namespace, s.HelmChartSubscription.Spec.SecretRef)
+	if err != nil {
+		klog.Error(err, "Failed to retrieve secret ", s.HelmChartSubscription.Spec.SecretRef.Name)
+		return nil, "", err
+	}
+
+	//The same directory is used across the polls to reuse the working copy
+	chartsDir, err := utils.GetChartsDir()
+	if err != nil {
+		return nil, "", err
+	}
 
 	github := s.HelmChartSubscription.Spec.Source.GitHub
+	revision := utils.GitRevision{
+		Branch: github.Branch,
+		Tag:    github.Tag,
+		Commit: github.Commit,
+	}
 
-	indexFile, hash, err := utils.GenerateGitHubIndexFile(configMap,
+	destRepo := utils.GitWorkingCopyDir(chartsDir, github.Urls, revision)
+
+	//Clone only if the remote reference moved since the last clone
+	remoteHash, err := utils.GetGitRemoteHash(ctx, configMap, secret, github.Urls, revision)
+	if err != nil {
+		klog.V(3).Info(err, " - Unable to list the remote references, cloning")
+	} else if s.HelmRepoHash != "" && remoteHash == s.gitRemoteHash {
+		klog.V(5).Info("Remote reference didn't move: ", remoteHash)
+		return nil, s.HelmRepoHash, nil
+	}
+
+	indexFile, hash, err := utils.GenerateGitHubIndexFile(ctx,
+		configMap,
 		secret,
 		destRepo,
 		github.Urls,
 		github.ChartsPath,
-		github.Branch)
+		revision)
 	if err != nil {
 		klog.Error(err, " - Can not generate index file")
 		return nil, "", err
 	}
 
+	s.gitRemoteHash = remoteHash
+
 	return indexFile, hash, nil
 }
 
@@ -419,7 +706,16 @@ func (s *HelmRepoSubscriber) takeLatestVersion(indexFile *repo.IndexFile) (err e
 	return nil
 }
 
-func (s *HelmRepoSubscriber) manageHelmChartSubscription(indexFile *repo.IndexFile) error {
+func (s *HelmRepoSubscriber) manageHelmChartSubscription(ctx context.Context, indexFile *repo.IndexFile) error {
+	manual := !strings.EqualFold(string(s.HelmChartSubscription.Spec.InstallPlanApproval), string(appv1alpha1.ApprovalAutomatic))
+
+	var pendingUpgrades map[string]appv1alpha1.PendingUpgrade
+
+	//The pending upgrades are reported even if a package fails
+	defer func() {
+		s.pendingUpgrades = pendingUpgrades
+	}()
+
 	//Loop on all packages selected by the subscription
 	for _, chartVersions := range indexFile.Entries {
 		if len(chartVersions) != 0 {
@@ -434,12 +730,46 @@ func (s *HelmRepoSubscriber) manageHelmChartSubscription(indexFile *repo.IndexFi
 			// Check if this Pod already exists
 			found := &appv1alpha1.HelmRelease{}
 
-			err = s.Client.Get(context.TODO(), types.NamespacedName{Name: sr.Name, Namespace: sr.Namespace}, found)
+			err = s.Client.Get(ctx, types.NamespacedName{Name: sr.Name, Namespace: sr.Namespace}, found)
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+
+			//In Manual mode the HelmRelease is installed once, a change waits for the approval of its spec
+			if manual && err == nil && !reflect.DeepEqual(found.Spec, sr.Spec) {
+				hash, hashErr := specHash(&sr.Spec)
+				if hashErr != nil {
+					return hashErr
+				}
+
+				if !s.isApproved(sr.Spec.ChartName, sr.Spec.Version, hash) {
+					if pendingUpgrades == nil {
+						pendingUpgrades = make(map[string]appv1alpha1.PendingUpgrade)
+					}
+
+					pendingUpgrade := appv1alpha1.PendingUpgrade{
+						CurrentVersion: found.Spec.Version,
+						Version:        sr.Spec.Version,
+						ValuesDiff:     diffValues(found.Spec.Values, sr.Spec.Values),
+						SpecHash:       hash,
+						DetectionTime:  metav1.Now(),
+					}
+
+					klog.Info("Upgrade of ", sr.Namespace, "/", sr.Name, " to ", sr.Spec.Version, " waiting for an approval")
+
+					pendingUpgrades[sr.Spec.ChartName] = pendingUpgrade
+
+					continue
+				}
+
+				klog.Info("Upgrade of ", sr.Namespace, "/", sr.Name, " to ", sr.Spec.Version, " approved")
+			}
+
 			if err != nil {
 				if errors.IsNotFound(err) {
 					klog.Info("Creating a new HelmRelease: ", sr.Namespace, "/", sr.Name)
 
-					err = s.Client.Create(context.TODO(), sr)
+					err = s.Client.Create(ctx, sr)
 					if err != nil {
 						return err
 					}
@@ -447,13 +777,24 @@ func (s *HelmRepoSubscriber) manageHelmChartSubscription(indexFile *repo.IndexFi
 					return err
 				}
 			} else {
-				if !reflect.DeepEqual(found.Spec, sr.Spec) || found.Status.Status != appv1alpha1.HelmReleaseSuccess {
+				uid := sr.Labels[appv1alpha1.HelmChartSubscriptionUIDLabel]
+
+				if !reflect.DeepEqual(found.Spec, sr.Spec) || found.Status.Status != appv1alpha1.HelmReleaseSuccess ||
+					found.Labels[appv1alpha1.HelmChartSubscriptionUIDLabel] != uid {
 					klog.Info("Update the HelmRelease: ", sr.Namespace, "/", sr.Name)
 					klog.V(5).Info("found Spec: ", found.Spec)
 					klog.V(5).Info("sr Spec", sr.Spec)
 					found.Spec = sr.Spec
 
-					err = s.Client.Update(context.TODO(), found)
+					if uid != "" {
+						if found.Labels == nil {
+							found.Labels = make(map[string]string)
+						}
+
+						found.Labels[appv1alpha1.HelmChartSubscriptionUIDLabel] = uid
+					}
+
+					err = s.Client.Update(ctx, found)
 					if err != nil {
 						return err
 					}
@@ -465,6 +806,85 @@ func (s *HelmRepoSubscriber) manageHelmChartSubscription(indexFile *repo.IndexFi
 	return nil
 }
 
+//isApproved returns true if the version and the spec hash of the package are in the approved upgrades,
+//an approval doesn't apply to a spec changed after it was given.
+func (s *HelmRepoSubscriber) isApproved(packageName string, version string, hash string) bool {
+	for _, approved := range s.HelmChartSubscription.Spec.ApprovedUpgrades {
+		if approved.PackageName == packageName && approved.Version == version && approved.SpecHash == hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+//specHash returns the sha256 of the HelmRelease spec, it identifies the candidate of a pending upgrade
+func specHash(spec *appv1alpha1.HelmReleaseSpec) (string, error) {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+//diffValues returns the lines removed from the old values prefixed by - and the lines
+//added by the new values prefixed by +, in the order of the values
+func diffValues(oldValues string, newValues string) string {
+	oldLines := splitLines(oldValues)
+	newLines := splitLines(newValues)
+
+	//lcs[i][j] is the length of the longest common subsequence of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			switch {
+			case oldLines[i] == newLines[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+
+	i, j := 0, 0
+
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			i++
+			j++
+		case j == len(newLines) || (i < len(oldLines) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff.WriteString("-" + oldLines[i] + "\n")
+			i++
+		default:
+			diff.WriteString("+" + newLines[j] + "\n")
+			j++
+		}
+	}
+
+	return diff.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, "\n")
+}
+
 // newHelmChartHelmReleaseForCR
 func (s *HelmRepoSubscriber) newHelmChartHelmReleaseForCR(chartVersion *repo.ChartVersion) (*appv1alpha1.HelmRelease, error) {
 	annotations := map[string]string{
@@ -479,29 +899,26 @@ func (s *HelmRepoSubscriber) newHelmChartHelmReleaseForCR(chartVersion *repo.Cha
 
 	releaseName := chartVersion.Name + "-" + s.HelmChartSubscription.Name + "-" + s.HelmChartSubscription.Namespace
 
-	for i := range chartVersion.URLs {
-		parsedURL, err := url.Parse(chartVersion.URLs[i])
-		if err != nil {
-			return nil, err
-		}
-
-		if parsedURL.Scheme == "local" {
-			//make sure there is one and only one slash
-			repoURL := strings.TrimSuffix(s.HelmChartSubscription.Spec.Source.HelmRepo.Urls[0], "/") + "/"
-			chartVersion.URLs[i] = strings.Replace(chartVersion.URLs[i], "local://", repoURL, -1)
-		}
+	//The subscription lists its HelmReleases by this label when it reports the package status
+	var labels map[string]string
+	if s.HelmChartSubscription.UID != "" {
+		labels = map[string]string{appv1alpha1.HelmChartSubscriptionUIDLabel: string(s.HelmChartSubscription.UID)}
 	}
+
+	//The local:// chart urls are resolved by utils.GetHelmRepoIndex against the url which served them
 	//Compose release name
 	sr := &appv1alpha1.HelmRelease{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        releaseName,
 			Namespace:   s.HelmChartSubscription.Namespace,
 			Annotations: annotations,
+			Labels:      labels,
 		},
 		Spec: appv1alpha1.HelmReleaseSpec{
 			Source:       &appv1alpha1.Source{},
 			ConfigMapRef: s.HelmChartSubscription.Spec.ConfigMapRef,
 			SecretRef:    s.HelmChartSubscription.Spec.SecretRef,
+			Verification: s.HelmChartSubscription.Spec.Verification,
 			ChartName:    chartVersion.Name,
 			ReleaseName:  releaseName,
 			Version:      chartVersion.GetVersion(),
@@ -512,14 +929,24 @@ func (s *HelmRepoSubscriber) newHelmChartHelmReleaseForCR(chartVersion *repo.Cha
 	switch strings.ToLower(string(s.HelmChartSubscription.Spec.Source.SourceType)) {
 	case string(appv1alpha1.HelmRepoSourceType):
 		sr.Spec.Source.SourceType = appv1alpha1.HelmRepoSourceType
-		sr.Spec.Source.HelmRepo = &appv1alpha1.HelmRepo{Urls: chartVersion.URLs}
+		sr.Spec.Source.HelmRepo = &appv1alpha1.HelmRepo{
+			Urls:    chartVersion.URLs,
+			RepoURL: s.repoChartSources[utils.ChartSourceKey(chartVersion)],
+		}
+		sr.Spec.Digest = chartVersion.Digest
 	case string(appv1alpha1.GitHubSourceType):
 		sr.Spec.Source.SourceType = appv1alpha1.GitHubSourceType
 		sr.Spec.Source.GitHub = &appv1alpha1.GitHub{
 			Urls:      s.HelmChartSubscription.Spec.Source.GitHub.Urls,
 			Branch:    s.HelmChartSubscription.Spec.Source.GitHub.Branch,
+			Tag:       s.HelmChartSubscription.Spec.Source.GitHub.Tag,
+			Commit:    s.HelmChartSubscription.Spec.Source.GitHub.Commit,
 			ChartPath: filepath.Join(s.HelmChartSubscription.Spec.Source.GitHub.ChartsPath, chartVersion.URLs[0]),
 		}
+	case string(appv1alpha1.OCISourceType):
+		sr.Spec.Source.SourceType = appv1alpha1.OCISourceType
+		sr.Spec.Source.OCI = &appv1alpha1.OCI{Urls: chartVersion.URLs}
+		sr.Spec.Digest = chartVersion.Digest
 	default:
 		return nil, fmt.Errorf("sourceType '%s' unsupported", s.HelmChartSubscription.Spec.Source.SourceType)
 	}
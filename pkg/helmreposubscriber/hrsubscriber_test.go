@@ -18,6 +18,7 @@ package helmreposubscriber
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -191,21 +192,23 @@ func TestRestart(t *testing.T) {
 	}
 
 	//Start subscriber
-	err = subscriber.Restart()
+	err = subscriber.Restart(context.TODO())
 	assert.NoError(t, err)
 
 	assert.Equal(t, true, subscriber.started)
 
-	time.Sleep(2 * time.Second)
-
+	//The first pass is delayed by the jitter
 	helmReleaseList := &appv1alpha1.HelmReleaseList{}
-	err = c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
-	assert.NoError(t, err)
 
-	assert.Equal(t, 1, len(helmReleaseList.Items))
+	g.Eventually(func() int {
+		err = c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
+		assert.NoError(t, err)
+
+		return len(helmReleaseList.Items)
+	}, 20*time.Second, 100*time.Millisecond).Should(gomega.Equal(1))
 
 	//Update subscriber
-	err = subscriber.Update(subscription)
+	err = subscriber.Update(context.TODO(), subscription)
 	assert.NoError(t, err)
 
 	assert.Equal(t, true, subscriber.started)
@@ -219,17 +222,17 @@ func TestRestart(t *testing.T) {
 
 	subscription.Spec.InstallPlanApproval = appv1alpha1.ApprovalManual
 
-	//Start subscriber in Manual mode
-	err = subscriber.Restart()
+	//Start subscriber in Manual mode, the new versions wait for an approval
+	err = subscriber.Restart(context.TODO())
 	assert.NoError(t, err)
 
-	assert.Equal(t, false, subscriber.started)
+	assert.Equal(t, true, subscriber.started)
 
 	//Update subscriber in Manual mode
-	err = subscriber.Update(subscription)
+	err = subscriber.Update(context.TODO(), subscription)
 	assert.NoError(t, err)
 
-	assert.Equal(t, false, subscriber.started)
+	assert.Equal(t, true, subscriber.started)
 
 	//Stop subscriber in Manual mode
 
@@ -283,41 +286,85 @@ func TestDoHelmChartSubscription(t *testing.T) {
 		HelmChartSubscription: subscription,
 	}
 
-	err = subscriber.doHelmChartSubscription()
-	assert.NoError(t, err)
+	helmReleaseList := &appv1alpha1.HelmReleaseList{}
 
-	time.Sleep(2 * time.Second)
+	//countHelmReleases lists the HelmReleases from the cache of the client, it follows the writes with a delay
+	countHelmReleases := func() int {
+		err := c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
+		assert.NoError(t, err)
 
-	helmReleaseList := &appv1alpha1.HelmReleaseList{}
-	err = c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
+		return len(helmReleaseList.Items)
+	}
+
+	//Manual mode, the HelmRelease is installed once without approval
+	err = subscriber.doHelmChartSubscription(context.TODO())
 	assert.NoError(t, err)
 
-	assert.Equal(t, 1, len(helmReleaseList.Items))
+	g.Eventually(countHelmReleases, 10*time.Second, 100*time.Millisecond).Should(gomega.Equal(1))
+	assert.Empty(t, subscriber.pendingUpgrades)
 
 	//Rerun for update, no new helmRelease must be created because hash didn't change
-	err = subscriber.doHelmChartSubscription()
+	err = subscriber.doHelmChartSubscription(context.TODO())
 	assert.NoError(t, err)
 
-	time.Sleep(2 * time.Second)
+	g.Consistently(countHelmReleases, time.Second, 100*time.Millisecond).Should(gomega.Equal(1))
 
-	helmReleaseList = &appv1alpha1.HelmReleaseList{}
-	err = c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
+	//Rerun for update, no new helmRelease must be created because already exist and Spec identical
+	subscriber.HelmRepoHash = ""
+	err = subscriber.doHelmChartSubscription(context.TODO())
 	assert.NoError(t, err)
 
-	assert.Equal(t, 1, len(helmReleaseList.Items))
+	g.Consistently(countHelmReleases, time.Second, 100*time.Millisecond).Should(gomega.Equal(1))
+	assert.Empty(t, subscriber.pendingUpgrades)
 
-	//Rerun for update, no new helmRelease must be created because already exist and Spec identical
+	//A change of the values waits for an approval
+	changed := &appv1alpha1.HelmChartSubscription{}
+	err = yaml.Unmarshal([]byte(strings.Replace(gitRepoSub, "att1: hello", "att1: bye", 1)), changed)
+	assert.NoError(t, err)
+
+	subscription.Spec.PackageOverrides = changed.Spec.PackageOverrides
+	subscriber.HelmRepoHash = ""
+
+	err = subscriber.doHelmChartSubscription(context.TODO())
+	assert.NoError(t, err)
+
+	pendingUpgrade := subscriber.pendingUpgrades["subscription-release-test-1"]
+	assert.Equal(t, "0.1.0", pendingUpgrade.CurrentVersion)
+	assert.Equal(t, "0.1.0", pendingUpgrade.Version)
+	assert.Equal(t, "-att1: hello\n+att1: bye\n", pendingUpgrade.ValuesDiff)
+	assert.NotEqual(t, "", pendingUpgrade.SpecHash)
+
+	//hrValues returns the values of the HelmRelease from the cache of the client
+	hrValues := func() string {
+		assert.Equal(t, 1, countHelmReleases())
+		return helmReleaseList.Items[0].Spec.Values
+	}
+
+	g.Consistently(hrValues, time.Second, 100*time.Millisecond).Should(gomega.Equal("att1: hello"))
+
+	//An approval given for another spec of the version doesn't apply
+	subscription.Spec.ApprovedUpgrades = []appv1alpha1.PackageUpgrade{
+		{PackageName: "subscription-release-test-1", Version: "0.1.0", SpecHash: "approved-before-the-values-changed"},
+	}
 	subscriber.HelmRepoHash = ""
-	err = subscriber.doHelmChartSubscription()
+
+	err = subscriber.doHelmChartSubscription(context.TODO())
 	assert.NoError(t, err)
 
-	time.Sleep(2 * time.Second)
+	assert.Equal(t, pendingUpgrade.SpecHash, subscriber.pendingUpgrades["subscription-release-test-1"].SpecHash)
+	g.Consistently(hrValues, time.Second, 100*time.Millisecond).Should(gomega.Equal("att1: hello"))
+
+	//Approve the pending upgrade
+	subscription.Spec.ApprovedUpgrades = []appv1alpha1.PackageUpgrade{
+		{PackageName: "subscription-release-test-1", Version: "0.1.0", SpecHash: pendingUpgrade.SpecHash},
+	}
+	subscriber.HelmRepoHash = ""
 
-	helmReleaseList = &appv1alpha1.HelmReleaseList{}
-	err = c.List(context.TODO(), helmReleaseList, &client.ListOptions{})
+	err = subscriber.doHelmChartSubscription(context.TODO())
 	assert.NoError(t, err)
 
-	assert.Equal(t, 1, len(helmReleaseList.Items))
+	g.Eventually(hrValues, 10*time.Second, 100*time.Millisecond).Should(gomega.Equal("att1: bye"))
+	assert.Empty(t, subscriber.pendingUpgrades)
 
 	for _, hr := range helmReleaseList.Items {
 		err = c.Delete(context.TODO(), &hr)
@@ -637,3 +684,60 @@ func TestGetValues(t *testing.T) {
 
 	assert.Equal(t, "att1: hello", values)
 }
+
+func TestPollInterval(t *testing.T) {
+	sub := &appv1alpha1.HelmChartSubscription{}
+
+	assert.Equal(t, Options.DefaultPollInterval, PollInterval(sub))
+
+	sub.Spec.PollInterval = &metav1.Duration{Duration: time.Hour}
+	assert.Equal(t, time.Hour, PollInterval(sub))
+
+	sub.Spec.PollInterval = &metav1.Duration{Duration: time.Millisecond}
+	assert.Equal(t, Options.MinPollInterval, PollInterval(sub))
+
+	assert.NoError(t, Options.Validate())
+	assert.Error(t, SubscriberCMDOptions{DefaultPollInterval: time.Second, MinPollInterval: time.Minute}.Validate())
+	assert.Error(t, SubscriberCMDOptions{DefaultPollInterval: time.Second}.Validate())
+}
+
+func TestTriggerSync(t *testing.T) {
+	subscriber := &HelmRepoSubscriber{
+		HelmChartSubscription: &appv1alpha1.HelmChartSubscription{
+			Spec: appv1alpha1.HelmChartSubscriptionSpec{
+				Source: &appv1alpha1.SourceSubscription{
+					SourceType: appv1alpha1.GitHubSourceType,
+					GitHub: &appv1alpha1.GitHubSubscription{
+						Urls: []string{"https://github.com/IBM/multicloud-operators-subscription-release.git"},
+					},
+				},
+			},
+		},
+	}
+
+	urls := []string{"git@github.com:IBM/multicloud-operators-subscription-release.git"}
+
+	//Not started
+	assert.False(t, subscriber.TriggerSync(urls, "master"))
+
+	subscriber.started = true
+	subscriber.syncCh = make(chan struct{}, 1)
+
+	assert.True(t, subscriber.TriggerSync(urls, "master"))
+	assert.True(t, subscriber.TriggerSync(urls, ""))
+	assert.Equal(t, 1, len(subscriber.syncCh))
+
+	assert.False(t, subscriber.TriggerSync(urls, "feature"))
+	assert.False(t, subscriber.TriggerSync([]string{"https://github.com/IBM/other.git"}, "master"))
+
+	//A tag doesn't follow the pushes
+	subscriber.HelmChartSubscription.Spec.Source.GitHub.Tag = "v1.0.0"
+	assert.False(t, subscriber.TriggerSync(urls, "master"))
+}
+
+func TestDiffValues(t *testing.T) {
+	assert.Equal(t, "", diffValues("a: 1\nb: 2\n", "a: 1\nb: 2"))
+	assert.Equal(t, "+att1: hello\n", diffValues("", "att1: hello\n"))
+	assert.Equal(t, "-b: 2\n+b: 3\n+c: 4\n", diffValues("a: 1\nb: 2\n", "a: 1\nb: 3\nc: 4\n"))
+	assert.Equal(t, "-a: 1\n", diffValues("a: 1\nb: 2", "b: 2"))
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subscriber
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+	"github.com/IBM/multicloud-operators-subscription-release/pkg/helmreposubscriber"
+)
+
+func init() {
+	// The HelmRepoSubscriber handles the helm repositories, the git repositories and the OCI registries
+	for _, sourceType := range []appv1alpha1.SourceTypeEnum{
+		appv1alpha1.HelmRepoSourceType,
+		appv1alpha1.GitHubSourceType,
+		appv1alpha1.OCISourceType,
+	} {
+		Register(sourceType, newHelmRepoSubscriber)
+	}
+}
+
+func newHelmRepoSubscriber(client client.Client, scheme *runtime.Scheme, s *appv1alpha1.HelmChartSubscription) appv1alpha1.Subscriber {
+	return &helmreposubscriber.HelmRepoSubscriber{
+		Client:                client,
+		Scheme:                scheme,
+		HelmChartSubscription: s,
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subscriber
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+//Factory creates the subscriber of a HelmChartSubscription, the subscriber is started by the controller
+type Factory func(client client.Client, scheme *runtime.Scheme, s *appv1alpha1.HelmChartSubscription) appv1alpha1.Subscriber
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = make(map[string]Factory)
+)
+
+//Register makes the factory available for the sourceType, the source type is case insensitive.
+//It panics if the sourceType is already registered, it is expected to be called from an init function.
+func Register(sourceType appv1alpha1.SourceTypeEnum, factory Factory) {
+	key := strings.ToLower(string(sourceType))
+	if key == "" || factory == nil {
+		panic("subscriber: Register requires a sourceType and a factory")
+	}
+
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	if _, ok := factories[key]; ok {
+		panic(fmt.Sprintf("subscriber: Register called twice for sourceType '%s'", sourceType))
+	}
+
+	factories[key] = factory
+}
+
+//New returns a new subscriber for the source type of the HelmChartSubscription
+func New(client client.Client, scheme *runtime.Scheme, s *appv1alpha1.HelmChartSubscription) (appv1alpha1.Subscriber, error) {
+	if s.Spec.Source == nil {
+		return nil, fmt.Errorf("chartsSource is not defined")
+	}
+
+	factoriesMutex.RLock()
+	factory, ok := factories[strings.ToLower(string(s.Spec.Source.SourceType))]
+	factoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("sourceType '%s' unsupported, supported: %v", s.Spec.Source.SourceType, SourceTypes())
+	}
+
+	return factory(client, scheme, s), nil
+}
+
+//SourceTypes returns the sorted source types having a registered factory
+func SourceTypes() []string {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+
+	sourceTypes := make([]string, 0, len(factories))
+	for sourceType := range factories {
+		sourceTypes = append(sourceTypes, sourceType)
+	}
+
+	sort.Strings(sourceTypes)
+
+	return sourceTypes
+}
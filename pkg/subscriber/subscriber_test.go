@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subscriber
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+	"github.com/IBM/multicloud-operators-subscription-release/pkg/helmreposubscriber"
+)
+
+type inHouseSubscriber struct {
+	s *appv1alpha1.HelmChartSubscription
+}
+
+func (i *inHouseSubscriber) Restart(ctx context.Context) error { return nil }
+
+func (i *inHouseSubscriber) Stop() error { return nil }
+
+func (i *inHouseSubscriber) Update(ctx context.Context, s *appv1alpha1.HelmChartSubscription) error {
+	i.s = s
+	return nil
+}
+
+func (i *inHouseSubscriber) IsStarted() bool { return true }
+
+//unregister removes the factory registered by a test, so the test can run again
+func unregister(sourceType appv1alpha1.SourceTypeEnum) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	delete(factories, strings.ToLower(string(sourceType)))
+}
+
+func TestRegister(t *testing.T) {
+	defer unregister("InHouse")
+
+	Register("InHouse", func(client client.Client, scheme *runtime.Scheme, s *appv1alpha1.HelmChartSubscription) appv1alpha1.Subscriber {
+		return &inHouseSubscriber{s: s}
+	})
+
+	assert.Equal(t, []string{"github", "helmrepo", "inhouse", "oci"}, SourceTypes())
+
+	assert.Panics(t, func() {
+		Register("inhouse", newHelmRepoSubscriber)
+	})
+
+	sub := &appv1alpha1.HelmChartSubscription{
+		Spec: appv1alpha1.HelmChartSubscriptionSpec{
+			Source: &appv1alpha1.SourceSubscription{
+				SourceType: "inhouse",
+			},
+		},
+	}
+
+	s, err := New(nil, nil, sub)
+	assert.NoError(t, err)
+	assert.IsType(t, &inHouseSubscriber{}, s)
+
+	sub.Spec.Source.SourceType = appv1alpha1.GitHubSourceType
+
+	s, err = New(nil, nil, sub)
+	assert.NoError(t, err)
+	assert.IsType(t, &helmreposubscriber.HelmRepoSubscriber{}, s)
+
+	sub.Spec.Source.SourceType = "unknown"
+
+	_, err = New(nil, nil, sub)
+	assert.Error(t, err)
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+const (
+	//AuthHeaderSecretKey key in the secret holding the value of the Authorization header
+	AuthHeaderSecretKey = "authHeader"
+	//BearerTokenSecretKey key in the secret holding a bearer token
+	BearerTokenSecretKey = "bearerToken"
+	//HeadersSecretKey key in the secret holding additional headers, one "<name>: <value>" per line
+	HeadersSecretKey = "headers"
+	//TokenURLSecretKey key in the secret holding the url where the credentials are exchanged for a token
+	TokenURLSecretKey = "tokenURL"
+	//IdentityTokenSecretKey key in the secret holding the identity token exchanged at the tokenURL
+	IdentityTokenSecretKey = "identityToken"
+)
+
+const (
+	//defaultTokenLifetime is used when the token service doesn't return the lifetime of the token
+	defaultTokenLifetime = 5 * time.Minute
+	//tokenRefreshMargin a token is renewed when it expires in less than the margin
+	tokenRefreshMargin = 30 * time.Second
+)
+
+//AuthProvider adds the credentials of the secret to the requests sent to a repository
+type AuthProvider interface {
+	Authenticate(httpClient rest.HTTPClient, req *http.Request) error
+}
+
+//credentialSecretKeys are the keys of the secret read by GetAuthProvider
+var credentialSecretKeys = []string{
+	"user",
+	"password",
+	HeadersSecretKey,
+	TokenURLSecretKey,
+	IdentityTokenSecretKey,
+	AuthHeaderSecretKey,
+	BearerTokenSecretKey,
+}
+
+//secretForURL returns the secret used to download fileURL, the credentials are removed from the secret
+//unless fileURL has the scheme and the host of repoURL, the index of a repository can point at other hosts.
+//The client certificate and the proxy settings of the secret are kept.
+func secretForURL(secret *corev1.Secret, repoURL string, fileURL string) *corev1.Secret {
+	if secret == nil || repoURL == "" || isSameOrigin(repoURL, fileURL) {
+		return secret
+	}
+
+	withoutCredentials := secret.DeepCopy()
+	for _, key := range credentialSecretKeys {
+		delete(withoutCredentials.Data, key)
+	}
+
+	return withoutCredentials
+}
+
+//isSameOrigin returns true if both urls have the same scheme and host
+func isSameOrigin(url1 string, url2 string) bool {
+	u1, err := url.Parse(url1)
+	if err != nil {
+		return false
+	}
+
+	u2, err := url.Parse(url2)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u1.Scheme, u2.Scheme) && strings.EqualFold(u1.Host, u2.Host)
+}
+
+//GetAuthProvider returns the AuthProvider for the secret, the token exchange takes precedence
+//over the authHeader, the bearerToken and the basic authentication. The headers are added whatever
+//the authentication.
+func GetAuthProvider(secret *corev1.Secret) (AuthProvider, error) {
+	if secret == nil || secret.Data == nil {
+		return authProviders{}, nil
+	}
+
+	providers := make(authProviders, 0)
+
+	if headers, ok := secret.Data[HeadersSecretKey]; ok {
+		h, err := parseHeaders(string(headers))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in secret %s: %v", HeadersSecretKey, secret.Name, err)
+		}
+
+		providers = append(providers, &headerAuth{headers: h})
+	}
+
+	user, hasUser := secret.Data["user"]
+
+	switch {
+	case secret.Data[TokenURLSecretKey] != nil:
+		providers = append(providers, &tokenExchangeAuth{
+			tokenURL:      string(secret.Data[TokenURLSecretKey]),
+			user:          string(user),
+			password:      GetPassword(secret),
+			identityToken: string(secret.Data[IdentityTokenSecretKey]),
+		})
+	case secret.Data[AuthHeaderSecretKey] != nil:
+		providers = append(providers, &headerAuth{
+			headers: http.Header{"Authorization": []string{string(secret.Data[AuthHeaderSecretKey])}},
+		})
+	case secret.Data[BearerTokenSecretKey] != nil:
+		providers = append(providers, &headerAuth{
+			headers: http.Header{"Authorization": []string{"Bearer " + string(secret.Data[BearerTokenSecretKey])}},
+		})
+	case hasUser:
+		//The password may be empty, as for the registries taking a token as user
+		providers = append(providers, &basicAuth{user: string(user), password: GetPassword(secret)})
+	}
+
+	return providers, nil
+}
+
+//parseHeaders parses "<name>: <value>" lines
+func parseHeaders(s string) (http.Header, error) {
+	headers := make(http.Header)
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("header %q is not <name>: <value>", line)
+		}
+
+		headers.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+
+	return headers, nil
+}
+
+//authProviders applies the providers in order
+type authProviders []AuthProvider
+
+func (p authProviders) Authenticate(httpClient rest.HTTPClient, req *http.Request) error {
+	for _, provider := range p {
+		if err := provider.Authenticate(httpClient, req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type basicAuth struct {
+	user     string
+	password string
+}
+
+func (a *basicAuth) Authenticate(httpClient rest.HTTPClient, req *http.Request) error {
+	klog.V(5).Info("Add basic authentication")
+	req.SetBasicAuth(a.user, a.password)
+
+	return nil
+}
+
+type headerAuth struct {
+	headers http.Header
+}
+
+func (a *headerAuth) Authenticate(httpClient rest.HTTPClient, req *http.Request) error {
+	for name, values := range a.headers {
+		klog.V(5).Info("Add header ", name)
+
+		req.Header.Del(name)
+
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	return nil
+}
+
+//tokenExchangeAuth exchanges the user and password or the identity token for a short lived token
+//at the tokenURL, as done by the Harbor and Artifactory token services. The token is sent as a
+//bearer token and reused until it expires.
+type tokenExchangeAuth struct {
+	tokenURL      string
+	user          string
+	password      string
+	identityToken string
+}
+
+func (a *tokenExchangeAuth) Authenticate(httpClient rest.HTTPClient, req *http.Request) error {
+	key := a.cacheKey()
+
+	token, ok := exchangedTokens.get(key)
+	if !ok {
+		var lifetime time.Duration
+
+		var err error
+
+		//The exchange is cancelled with the request
+		token, lifetime, err = a.exchange(req.Context(), httpClient)
+		if err != nil {
+			return err
+		}
+
+		exchangedTokens.set(key, token, time.Now().Add(lifetime))
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+//cacheKey identifies the token service and the credentials, the credentials are not kept in the cache
+func (a *tokenExchangeAuth) cacheKey() string {
+	h := sha256.New()
+
+	for _, s := range []string{a.tokenURL, a.user, a.password, a.identityToken} {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *tokenExchangeAuth) exchange(ctx context.Context, httpClient rest.HTTPClient) (token string, lifetime time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.tokenURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case a.identityToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.identityToken)
+	case a.user != "":
+		req.SetBasicAuth(a.user, a.password)
+	}
+
+	klog.V(5).Info("Exchange the credentials for a token at ", a.tokenURL)
+
+	resp, err := doWithRetry(httpClient, req)
+	if err != nil {
+		klog.Error(err, " - Token exchange failed: ", a.tokenURL)
+		return "", 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, newStatusError(a.tokenURL, resp.StatusCode)
+	}
+
+	result := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", 0, err
+	}
+
+	token = result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+
+	if token == "" {
+		return "", 0, fmt.Errorf("no token returned by %s", a.tokenURL)
+	}
+
+	lifetime = defaultTokenLifetime
+	if result.ExpiresIn > 0 {
+		lifetime = time.Duration(result.ExpiresIn) * time.Second
+	}
+
+	return token, lifetime, nil
+}
+
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+//tokenCache keeps the exchanged tokens until they expire, the expired tokens are removed when a token is added
+type tokenCache struct {
+	mutex  sync.Mutex
+	tokens map[string]cachedToken
+}
+
+var exchangedTokens = &tokenCache{tokens: make(map[string]cachedToken)}
+
+func (c *tokenCache) get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t, ok := c.tokens[key]
+	if !ok || time.Now().Add(tokenRefreshMargin).After(t.expiry) {
+		delete(c.tokens, key)
+		return "", false
+	}
+
+	return t.token, true
+}
+
+func (c *tokenCache) set(key string, token string, expiry time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	for k, t := range c.tokens {
+		if now.After(t.expiry) {
+			delete(c.tokens, k)
+		}
+	}
+
+	c.tokens[key] = cachedToken{token: token, expiry: expiry}
+}
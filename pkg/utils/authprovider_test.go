@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+func authenticatedRequest(t *testing.T, secret *corev1.Secret) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://charts.example.com/index.yaml", nil)
+	assert.NoError(t, err)
+
+	auth, err := GetAuthProvider(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, auth.Authenticate(http.DefaultClient, req)
+}
+
+func TestGetAuthProvider(t *testing.T) {
+	req, err := authenticatedRequest(t, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", req.Header.Get("Authorization"))
+
+	req, err = authenticatedRequest(t, &corev1.Secret{Data: map[string][]byte{
+		"user":     []byte("user"),
+		"password": []byte("password"),
+	}})
+	assert.NoError(t, err)
+
+	user, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "password", password)
+
+	//The password may be empty
+	req, err = authenticatedRequest(t, &corev1.Secret{Data: map[string][]byte{"user": []byte("token")}})
+	assert.NoError(t, err)
+
+	user, password, ok = req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "token", user)
+	assert.Equal(t, "", password)
+
+	req, err = authenticatedRequest(t, &corev1.Secret{Data: map[string][]byte{
+		AuthHeaderSecretKey: []byte("Token xxxxxx"),
+		"user":              []byte("user"),
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "Token xxxxxx", req.Header.Get("Authorization"))
+
+	req, err = authenticatedRequest(t, &corev1.Secret{Data: map[string][]byte{
+		BearerTokenSecretKey: []byte("xxxxxx"),
+		HeadersSecretKey:     []byte("X-JFrog-Art-Api: key\n\nX-Tenant: tenant\n"),
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer xxxxxx", req.Header.Get("Authorization"))
+	assert.Equal(t, "key", req.Header.Get("X-JFrog-Art-Api"))
+	assert.Equal(t, "tenant", req.Header.Get("X-Tenant"))
+
+	_, err = authenticatedRequest(t, &corev1.Secret{Data: map[string][]byte{HeadersSecretKey: []byte("X-Tenant")}})
+	assert.Error(t, err)
+}
+
+func TestTokenExchangeAuth(t *testing.T) {
+	exchanges := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer identity" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		exchanges++
+		_, _ = w.Write([]byte(`{"access_token": "short-lived", "expires_in": 3600}`))
+	}))
+
+	defer tokenServer.Close()
+
+	chartArchive, err := ioutil.ReadFile("../../test/helmrepo/subscription-release-test-1-0.1.0.tgz")
+	assert.NoError(t, err)
+
+	//The index and the chart archive require the token
+	repoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer short-lived" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/index.yaml":
+			_, _ = w.Write([]byte(mirrorIndex))
+		default:
+			_, _ = w.Write(chartArchive)
+		}
+	}))
+
+	defer repoServer.Close()
+
+	secret := &corev1.Secret{Data: map[string][]byte{
+		TokenURLSecretKey:      []byte(tokenServer.URL),
+		IdentityTokenSecretKey: []byte("identity"),
+	}}
+
+	indexFile, _, _, err := GetHelmRepoIndex(context.TODO(), nil, secret, "default", []string{repoServer.URL}, "")
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	chartZip, err := downloadFile(context.TODO(), "default", nil, indexFile.Entries["subscription-release-test-1"][0].URLs[0], secret, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "subscription-release-test-1-0.2.0.tgz"), chartZip)
+
+	//The token is reused until it expires
+	assert.Equal(t, 1, exchanges)
+
+	secret.Data[IdentityTokenSecretKey] = []byte("revoked")
+
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), nil, secret, "default", []string{repoServer.URL}, "")
+	assert.Error(t, err)
+}
+
+func TestChartURLCredentials(t *testing.T) {
+	chartArchive, err := ioutil.ReadFile("../../test/helmrepo/subscription-release-test-1-0.1.0.tgz")
+	assert.NoError(t, err)
+
+	//The chart server is another host, it must not receive the credentials of the repository
+	var chartRequests []*http.Request
+
+	chartServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chartRequests = append(chartRequests, r)
+		_, _ = w.Write(chartArchive)
+	}))
+
+	defer chartServer.Close()
+
+	repoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(strings.ReplaceAll(`apiVersion: v1
+entries:
+  subscription-release-test-1:
+  - name: subscription-release-test-1
+    version: 0.1.0
+    urls:
+    - CHART_SERVER/subscription-release-test-1-0.1.0.tgz
+`, "CHART_SERVER", chartServer.URL)))
+	}))
+
+	defer repoServer.Close()
+
+	secret := &corev1.Secret{Data: map[string][]byte{
+		"user":           []byte("admin"),
+		"password":       []byte("secret"),
+		HeadersSecretKey: []byte("X-Tenant: tenant"),
+	}}
+
+	indexFile, _, chartSources, err := GetHelmRepoIndex(context.TODO(), nil, secret, "default", []string{repoServer.URL}, "")
+	assert.NoError(t, err)
+
+	chartVersion := indexFile.Entries["subscription-release-test-1"][0]
+	assert.Equal(t, repoServer.URL, chartSources[ChartSourceKey(chartVersion)])
+
+	hr := &appv1alpha1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "subscription-release-test-1-cr",
+			Namespace: "default",
+		},
+		Spec: appv1alpha1.HelmReleaseSpec{
+			Source: &appv1alpha1.Source{
+				SourceType: appv1alpha1.HelmRepoSourceType,
+				HelmRepo: &appv1alpha1.HelmRepo{
+					Urls:    chartVersion.URLs,
+					RepoURL: chartSources[ChartSourceKey(chartVersion)],
+				},
+			},
+			ChartName:   "subscription-release-test-1",
+			ReleaseName: "subscription-release-test-1",
+		},
+	}
+
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	chartDir, err := DownloadChart(context.TODO(), nil, secret, nil, dir, hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(chartRequests))
+
+	for _, r := range chartRequests {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Empty(t, r.Header.Get("X-Tenant"))
+	}
+
+	//The secret is not modified
+	assert.Equal(t, "admin", string(secret.Data["user"]))
+
+	//The chart urls of the repository host get the credentials
+	assert.Equal(t, secret, secretForURL(secret, repoServer.URL, repoServer.URL+"/charts/subscription-release-test-1-0.1.0.tgz"))
+	assert.Equal(t, secret, secretForURL(secret, "", chartServer.URL+"/subscription-release-test-1-0.1.0.tgz"))
+	assert.Empty(t, secretForURL(secret, "https://charts.example.com", "http://charts.example.com/chart.tgz").Data)
+}
+
+func TestTokenCache(t *testing.T) {
+	c := &tokenCache{tokens: make(map[string]cachedToken)}
+
+	c.set("expired", "token", time.Now().Add(-time.Second))
+	c.set("valid", "token", time.Now().Add(time.Hour))
+
+	//The expired tokens are removed
+	assert.Equal(t, 1, len(c.tokens))
+
+	token, ok := c.get("valid")
+	assert.True(t, ok)
+	assert.Equal(t, "token", token)
+
+	c.set("renewed", "token", time.Now().Add(tokenRefreshMargin/2))
+
+	_, ok = c.get("renewed")
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(c.tokens))
+}
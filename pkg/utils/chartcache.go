@@ -0,0 +1,307 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+const (
+	//ChartCacheDirName directory of the charts cache in the charts directory
+	ChartCacheDirName = ".cache"
+
+	defaultChartCacheMaxSize int64 = 1 << 30
+	defaultChartCacheMaxAge        = 7 * 24 * time.Hour
+)
+
+var (
+	//chartCacheMutex serializes the accesses to the caches, the releases are reconciled concurrently
+	chartCacheMutex sync.Mutex
+	//chartCacheInUse counts the users of each entry, an entry in use is neither evicted nor removed
+	chartCacheInUse = make(map[string]int)
+	//chartCacheRemoved the entries removed while in use, they are removed once released
+	chartCacheRemoved = make(map[string]bool)
+)
+
+//ChartCache is a content addressable cache of chart archives shared by the releases,
+//an archive is stored as <Dir>/<sha256>/<archive name> as the provenance file references the archive name.
+type ChartCache struct {
+	Dir     string
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+//NewChartCache returns the cache located in dir, the limits are read from the environment
+func NewChartCache(dir string) *ChartCache {
+	cache := &ChartCache{
+		Dir:     dir,
+		MaxSize: defaultChartCacheMaxSize,
+		MaxAge:  defaultChartCacheMaxAge,
+	}
+
+	if maxSize := os.Getenv(appv1alpha1.ChartsCacheMaxSize); maxSize != "" {
+		q, err := resource.ParseQuantity(maxSize)
+		if err != nil {
+			klog.Error(err, " - Invalid ", appv1alpha1.ChartsCacheMaxSize, ", using the default")
+		} else {
+			cache.MaxSize = q.Value()
+		}
+	}
+
+	if maxAge := os.Getenv(appv1alpha1.ChartsCacheMaxAge); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			klog.Error(err, " - Invalid ", appv1alpha1.ChartsCacheMaxAge, ", using the default")
+		} else {
+			cache.MaxAge = d
+		}
+	}
+
+	return cache
+}
+
+//Get returns the archive with the digest, the archive is checked against the digest before being reused.
+//The archive is kept in the cache until it is released with Release.
+func (c *ChartCache) Get(digest string) (string, bool) {
+	chartCacheMutex.Lock()
+	defer chartCacheMutex.Unlock()
+
+	entryDir := filepath.Join(c.Dir, digest)
+	if chartCacheRemoved[entryDir] {
+		return "", false
+	}
+
+	chartZip := c.archive(entryDir)
+	if chartZip == "" {
+		return "", false
+	}
+
+	actual, err := fileSHA256(chartZip)
+	if err != nil || actual != digest {
+		klog.Info("Removing corrupted chart from cache: ", chartZip)
+		c.removeEntry(entryDir)
+
+		return "", false
+	}
+
+	c.touch(entryDir)
+
+	chartCacheInUse[entryDir]++
+
+	return chartZip, true
+}
+
+//TempFile creates a file in the cache directory to download an archive
+func (c *ChartCache) TempFile() (string, error) {
+	err := os.MkdirAll(c.Dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile(c.Dir, "download-")
+	if err != nil {
+		return "", err
+	}
+
+	return f.Name(), f.Close()
+}
+
+//Add moves the file into the cache as the archive name with the digest and evicts the old archives.
+//The archive is kept in the cache until it is released with Release.
+func (c *ChartCache) Add(file string, name string, digest string) (string, error) {
+	chartCacheMutex.Lock()
+	defer chartCacheMutex.Unlock()
+
+	entryDir := filepath.Join(c.Dir, digest)
+
+	err := os.MkdirAll(entryDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	chartZip := filepath.Join(entryDir, name)
+
+	err = os.Rename(file, chartZip)
+	if err != nil {
+		return "", err
+	}
+
+	//The new archive replaces a removed one
+	delete(chartCacheRemoved, entryDir)
+
+	c.touch(entryDir)
+
+	chartCacheInUse[entryDir]++
+
+	c.evict(digest)
+
+	return chartZip, nil
+}
+
+//Release releases an archive returned by Get or Add, a removed archive is deleted by its last user
+func (c *ChartCache) Release(chartZip string) {
+	chartCacheMutex.Lock()
+	defer chartCacheMutex.Unlock()
+
+	entryDir := filepath.Dir(chartZip)
+
+	if chartCacheInUse[entryDir] > 1 {
+		chartCacheInUse[entryDir]--
+		return
+	}
+
+	delete(chartCacheInUse, entryDir)
+
+	if chartCacheRemoved[entryDir] {
+		delete(chartCacheRemoved, entryDir)
+		os.RemoveAll(entryDir)
+	}
+}
+
+//Remove removes the archive from the cache, an archive in use is removed once released
+func (c *ChartCache) Remove(chartZip string) {
+	chartCacheMutex.Lock()
+	defer chartCacheMutex.Unlock()
+
+	if filepath.Dir(filepath.Dir(chartZip)) == filepath.Clean(c.Dir) {
+		c.removeEntry(filepath.Dir(chartZip))
+	}
+}
+
+//removeEntry removes the entry or marks it to be removed by its last user
+func (c *ChartCache) removeEntry(entryDir string) {
+	if chartCacheInUse[entryDir] > 0 {
+		chartCacheRemoved[entryDir] = true
+		return
+	}
+
+	os.RemoveAll(entryDir)
+}
+
+//archive returns the archive of the entry or an empty string if the entry doesn't exist
+func (c *ChartCache) archive(entryDir string) string {
+	files, err := ioutil.ReadDir(entryDir)
+	if err != nil {
+		return ""
+	}
+
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasSuffix(f.Name(), ".prov") {
+			return filepath.Join(entryDir, f.Name())
+		}
+	}
+
+	return ""
+}
+
+//touch records the last use of the entry
+func (c *ChartCache) touch(entryDir string) {
+	now := time.Now()
+
+	err := os.Chtimes(entryDir, now, now)
+	if err != nil {
+		klog.Error(err, " - Unable to update the last use of ", entryDir)
+	}
+}
+
+type chartCacheEntry struct {
+	dir     string
+	size    int64
+	lastUse time.Time
+}
+
+//evict removes the entries unused since MaxAge then the least recently used entries
+//until the cache size is under MaxSize, the keep entry and the entries in use are never removed.
+func (c *ChartCache) evict(keep string) {
+	dirs, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		klog.Error(err, " - Unable to read the charts cache ", c.Dir)
+		return
+	}
+
+	entries := make([]chartCacheEntry, 0)
+
+	var total int64
+
+	for _, d := range dirs {
+		if !d.IsDir() {
+			//Leftover of an interrupted download
+			if strings.HasPrefix(d.Name(), "download-") && time.Since(d.ModTime()) > c.MaxAge {
+				os.Remove(filepath.Join(c.Dir, d.Name()))
+			}
+
+			continue
+		}
+
+		entryDir := filepath.Join(c.Dir, d.Name())
+
+		if d.Name() == keep || chartCacheInUse[entryDir] > 0 {
+			total += dirSize(entryDir)
+			continue
+		}
+
+		if time.Since(d.ModTime()) > c.MaxAge {
+			klog.V(3).Info("Evicting expired chart from cache: ", entryDir)
+			os.RemoveAll(entryDir)
+
+			continue
+		}
+
+		entry := chartCacheEntry{dir: entryDir, size: dirSize(entryDir), lastUse: d.ModTime()}
+
+		total += entry.size
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUse.Before(entries[j].lastUse) })
+
+	for _, entry := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+
+		klog.V(3).Info("Evicting least recently used chart from cache: ", entry.dir)
+		os.RemoveAll(entry.dir)
+
+		total -= entry.size
+	}
+}
+
+//dirSize returns the size of the files of the entry
+func dirSize(entryDir string) int64 {
+	var size int64
+
+	if files, err := ioutil.ReadDir(entryDir); err == nil {
+		for _, f := range files {
+			size += f.Size()
+		}
+	}
+
+	return size
+}
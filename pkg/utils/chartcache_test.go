@@ -0,0 +1,200 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+func addToCache(t *testing.T, cache *ChartCache, name string, content string) (chartZip string, digest string) {
+	tmpFile, err := cache.TempFile()
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(tmpFile, []byte(content), 0644)
+	assert.NoError(t, err)
+
+	digest, err = fileSHA256(tmpFile)
+	assert.NoError(t, err)
+
+	chartZip, err = cache.Add(tmpFile, name, digest)
+	assert.NoError(t, err)
+
+	cache.Release(chartZip)
+
+	return chartZip, digest
+}
+
+func TestChartCache(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	cache := NewChartCache(filepath.Join(dir, ChartCacheDirName))
+
+	chartZip, digest := addToCache(t, cache, "chart-0.1.0.tgz", "chart-0.1.0")
+	assert.Equal(t, filepath.Join(cache.Dir, digest, "chart-0.1.0.tgz"), chartZip)
+
+	cached, ok := cache.Get(digest)
+	assert.True(t, ok)
+	assert.Equal(t, chartZip, cached)
+
+	cache.Release(cached)
+
+	//A corrupted archive is not reused
+	err = ioutil.WriteFile(chartZip, []byte("truncated"), 0644)
+	assert.NoError(t, err)
+
+	_, ok = cache.Get(digest)
+	assert.False(t, ok)
+
+	_, err = os.Stat(filepath.Dir(chartZip))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestChartCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	cache := NewChartCache(filepath.Join(dir, ChartCacheDirName))
+	cache.MaxSize = 20
+
+	_, digest1 := addToCache(t, cache, "chart-0.1.0.tgz", "chart-0.1.0")
+
+	//Expired entry
+	old := time.Now().Add(-2 * cache.MaxAge)
+	err = os.Chtimes(filepath.Join(cache.Dir, digest1), old, old)
+	assert.NoError(t, err)
+
+	_, digest2 := addToCache(t, cache, "chart-0.2.0.tgz", "chart-0.2.0")
+
+	_, ok := cache.Get(digest1)
+	assert.False(t, ok)
+
+	//The least recently used entry is evicted when the cache is full
+	_, digest3 := addToCache(t, cache, "chart-0.3.0.tgz", "chart-0.3.0")
+
+	_, ok = cache.Get(digest2)
+	assert.False(t, ok)
+
+	chartZip, ok := cache.Get(digest3)
+	assert.True(t, ok)
+
+	cache.Release(chartZip)
+}
+
+func TestChartCacheInUse(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	cache := NewChartCache(filepath.Join(dir, ChartCacheDirName))
+	cache.MaxSize = 20
+
+	_, digest1 := addToCache(t, cache, "chart-0.1.0.tgz", "chart-0.1.0")
+
+	chartZip1, ok := cache.Get(digest1)
+	assert.True(t, ok)
+
+	//An archive in use is neither evicted nor removed
+	old := time.Now().Add(-2 * cache.MaxAge)
+	err = os.Chtimes(filepath.Dir(chartZip1), old, old)
+	assert.NoError(t, err)
+
+	addToCache(t, cache, "chart-0.2.0.tgz", "chart-0.2.0")
+	addToCache(t, cache, "chart-0.3.0.tgz", "chart-0.3.0")
+
+	cache.Remove(chartZip1)
+
+	_, err = os.Stat(chartZip1)
+	assert.NoError(t, err)
+
+	//A removed archive is not returned and is deleted by its last user
+	_, ok = cache.Get(digest1)
+	assert.False(t, ok)
+
+	cache.Release(chartZip1)
+
+	_, err = os.Stat(filepath.Dir(chartZip1))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadChartFromHelmRepoCache(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	hr := &appv1alpha1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "subscription-release-test-1-cr",
+			Namespace: "default",
+		},
+		Spec: appv1alpha1.HelmReleaseSpec{
+			Source: &appv1alpha1.Source{
+				SourceType: appv1alpha1.HelmRepoSourceType,
+				HelmRepo: &appv1alpha1.HelmRepo{
+					Urls: []string{"file:../../test/helmrepo/subscription-release-test-1-0.1.0.tgz"},
+				},
+			},
+			ChartName:   "subscription-release-test-1",
+			ReleaseName: "subscription-release-test-1",
+			Digest:      "2b9ada622755a18b6b9ab72e942f819bf7c2ba7362f15d8e8bf8056429f38769",
+		},
+	}
+
+	chartDir, err := DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+	assert.NoError(t, err)
+
+	chartZip := filepath.Join(dir, ChartCacheDirName, hr.Spec.Digest, "subscription-release-test-1-0.1.0.tgz")
+	_, err = os.Stat(chartZip)
+	assert.NoError(t, err)
+
+	//The release is installed from the cache once the archive is downloaded
+	hr.Spec.Source.HelmRepo.Urls = []string{"file:../../test/helmrepo/notfound.tgz"}
+
+	_, err = DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	assert.NoError(t, err)
+
+	err = DeleteChartsDir(dir, hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, hr.Spec.ReleaseName))
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, hr.Spec.ReleaseName, hr.Namespace))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(chartZip)
+	assert.NoError(t, err)
+}
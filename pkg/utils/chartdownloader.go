@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+//ChartDownloader downloads the chart of the helmrelease into destRepo and returns the directory of the chart.
+//The chartsDir is the root directory of the charts, the downloader can keep a cache in it.
+type ChartDownloader func(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	keyringSecret *corev1.Secret,
+	chartsDir string,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error)
+
+type chartDownloaderEntry struct {
+	download ChartDownloader
+	//verifiesProvenance is true when the downloader verifies the chart with the keyringSecret
+	verifiesProvenance bool
+}
+
+var (
+	chartDownloadersMutex sync.RWMutex
+	chartDownloaders      = make(map[string]chartDownloaderEntry)
+)
+
+func init() {
+	RegisterChartDownloader(appv1alpha1.HelmRepoSourceType, downloadHelmRepoChart, true)
+	RegisterChartDownloader(appv1alpha1.GitHubSourceType, downloadGitHubChart, false)
+	RegisterChartDownloader(appv1alpha1.OCISourceType, downloadOCIChartSource, false)
+}
+
+//RegisterChartDownloader makes the downloader available to DownloadChart for the sourceType, the source
+//type is case insensitive. The charts requiring a provenance verification are refused for the sourceType
+//unless verifiesProvenance is true. It panics if the sourceType is already registered, it is expected to
+//be called from the init function of the package providing the downloader.
+func RegisterChartDownloader(sourceType appv1alpha1.SourceTypeEnum, downloader ChartDownloader, verifiesProvenance bool) {
+	key := strings.ToLower(string(sourceType))
+	if key == "" || downloader == nil {
+		panic("utils: RegisterChartDownloader requires a sourceType and a downloader")
+	}
+
+	chartDownloadersMutex.Lock()
+	defer chartDownloadersMutex.Unlock()
+
+	if _, ok := chartDownloaders[key]; ok {
+		panic(fmt.Sprintf("utils: RegisterChartDownloader called twice for sourceType '%s'", sourceType))
+	}
+
+	chartDownloaders[key] = chartDownloaderEntry{download: downloader, verifiesProvenance: verifiesProvenance}
+}
+
+//ChartDownloaderSourceTypes returns the sorted source types having a registered downloader
+func ChartDownloaderSourceTypes() []string {
+	chartDownloadersMutex.RLock()
+	defer chartDownloadersMutex.RUnlock()
+
+	sourceTypes := make([]string, 0, len(chartDownloaders))
+	for sourceType := range chartDownloaders {
+		sourceTypes = append(sourceTypes, sourceType)
+	}
+
+	sort.Strings(sourceTypes)
+
+	return sourceTypes
+}
+
+//getChartDownloader returns the downloader registered for the sourceType
+func getChartDownloader(sourceType appv1alpha1.SourceTypeEnum) (chartDownloaderEntry, bool) {
+	chartDownloadersMutex.RLock()
+	defer chartDownloadersMutex.RUnlock()
+
+	entry, ok := chartDownloaders[strings.ToLower(string(sourceType))]
+
+	return entry, ok
+}
+
+func downloadHelmRepoChart(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	keyringSecret *corev1.Secret,
+	chartsDir string,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+	cache := NewChartCache(filepath.Join(chartsDir, ChartCacheDirName))
+
+	return downloadChartFromHelmRepo(ctx, configMap, secret, keyringSecret, cache, destRepo, s)
+}
+
+func downloadGitHubChart(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	keyringSecret *corev1.Secret,
+	chartsDir string,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+	return DownloadChartFromGitHub(ctx, configMap, secret, destRepo, s)
+}
+
+func downloadOCIChartSource(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	keyringSecret *corev1.Secret,
+	chartsDir string,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+	cache := NewChartCache(filepath.Join(chartsDir, ChartCacheDirName))
+
+	return downloadChartFromOCI(ctx, configMap, secret, cache, destRepo, s)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+//unregisterChartDownloader removes the downloader registered by a test, so the test can run again
+func unregisterChartDownloader(sourceType appv1alpha1.SourceTypeEnum) {
+	chartDownloadersMutex.Lock()
+	defer chartDownloadersMutex.Unlock()
+
+	delete(chartDownloaders, strings.ToLower(string(sourceType)))
+}
+
+func TestRegisterChartDownloader(t *testing.T) {
+	const inHouseSourceType appv1alpha1.SourceTypeEnum = "InHouse"
+
+	var downloadedTo string
+
+	defer unregisterChartDownloader(inHouseSourceType)
+
+	RegisterChartDownloader(inHouseSourceType, func(ctx context.Context,
+		configMap *corev1.ConfigMap,
+		secret *corev1.Secret,
+		keyringSecret *corev1.Secret,
+		chartsDir string,
+		destRepo string,
+		s *appv1alpha1.HelmRelease) (string, error) {
+		downloadedTo = destRepo
+		return filepath.Join(destRepo, s.Spec.ChartName), nil
+	}, false)
+
+	assert.Equal(t, []string{"github", "helmrepo", "inhouse", "oci"}, ChartDownloaderSourceTypes())
+
+	assert.Panics(t, func() {
+		RegisterChartDownloader("inhouse", downloadGitHubChart, false)
+	})
+
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	hr := &appv1alpha1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "subscription-release-test-1-cr",
+			Namespace: "default",
+		},
+		Spec: appv1alpha1.HelmReleaseSpec{
+			Source: &appv1alpha1.Source{
+				SourceType: "inhouse",
+			},
+			ChartName:   "subscription-release-test-1",
+			ReleaseName: "subscription-release-test-1",
+		},
+	}
+
+	chartDir, err := DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "subscription-release-test-1", "default", "subscription-release-test-1"), downloadedTo)
+	assert.Equal(t, filepath.Join(downloadedTo, "subscription-release-test-1"), chartDir)
+
+	//The downloader doesn't verify the provenance
+	hr.Spec.Verification = &appv1alpha1.ChartVerification{Mode: appv1alpha1.VerificationModeProvenance}
+
+	_, err = DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	_, ok := err.(*ChartVerificationError)
+	assert.True(t, ok)
+
+	hr.Spec.Source.SourceType = "unknown"
+
+	_, err = DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	assert.Error(t, err)
+}
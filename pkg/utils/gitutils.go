@@ -0,0 +1,726 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	//SSHKeySecretKey key in the secret holding the ssh private key
+	SSHKeySecretKey = "sshKey"
+	//SSHKeyPassphraseSecretKey key in the secret holding the passphrase of the ssh private key
+	SSHKeyPassphraseSecretKey = "sshKeyPassphrase"
+	//KnownHostsSecretKey key in the secret holding the known_hosts entries of the git server
+	KnownHostsSecretKey = "knownHosts"
+	//GitWorkingCopyDirName directory of the git working copies of the subscriptions in the charts directory
+	GitWorkingCopyDirName = ".git-repos"
+)
+
+var commitSHARegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+var (
+	//gitWorkingCopyMutex protects gitWorkingCopyLocks
+	gitWorkingCopyMutex sync.Mutex
+	//gitWorkingCopyLocks serializes the updates and the reads of each working copy
+	gitWorkingCopyLocks = make(map[string]*sync.Mutex)
+)
+
+//GitRevision identifies the revision of a git repository to checkout,
+//the commit takes precedence over the tag and the tag over the branch.
+type GitRevision struct {
+	Branch string
+	Tag    string
+	Commit string
+}
+
+//GitWorkingCopyDir returns the directory of the working copy of the urls at the revision,
+//the working copy is shared by the subscriptions to the same repository and revision
+func GitWorkingCopyDir(chartsDir string, urls []string, revision GitRevision) string {
+	key := append(append([]string{}, urls...), revision.Branch, revision.Tag, revision.Commit)
+	h := sha256.Sum256([]byte(strings.Join(key, "\n")))
+
+	return filepath.Join(chartsDir, GitWorkingCopyDirName, hex.EncodeToString(h[:]))
+}
+
+//lockGitWorkingCopy locks the working copy in dir, it returns the unlock function
+func lockGitWorkingCopy(dir string) func() {
+	gitWorkingCopyMutex.Lock()
+
+	lock, ok := gitWorkingCopyLocks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		gitWorkingCopyLocks[dir] = lock
+	}
+
+	gitWorkingCopyMutex.Unlock()
+
+	lock.Lock()
+
+	return lock.Unlock
+}
+
+func (r GitRevision) validate() error {
+	if r.Commit != "" && !commitSHARegexp.MatchString(r.Commit) {
+		return fmt.Errorf("commit %s must be a full 40 characters SHA", r.Commit)
+	}
+
+	return nil
+}
+
+//referenceName returns the reference of the tag or of the branch, master by default
+func (r GitRevision) referenceName() plumbing.ReferenceName {
+	switch {
+	case r.Tag != "":
+		return plumbing.NewTagReferenceName(r.Tag)
+	case r.Branch != "":
+		return plumbing.NewBranchReferenceName(r.Branch)
+	default:
+		return plumbing.Master
+	}
+}
+
+//GitAuthError is returned when the authentication against the git repository failed
+type GitAuthError struct {
+	URL string
+	Err error
+}
+
+func (e *GitAuthError) Error() string {
+	return fmt.Sprintf("authentication failed for %s: %v", e.URL, e.Err)
+}
+
+func (e *GitAuthError) Unwrap() error {
+	return e.Err
+}
+
+//gitClientScheme prefixes the scheme of the http urls accessed with the http client of a gitClientAuth
+const gitClientScheme = "client+"
+
+func init() {
+	//go-git selects the transports by scheme only, the http and https transports of the other go-git users
+	//are left untouched and the urls needing the http client settings are routed through a private scheme
+	client.InstallProtocol(gitClientScheme+"http", gitHTTPTransport{})
+	client.InstallProtocol(gitClientScheme+"https", gitHTTPTransport{})
+}
+
+//gitURL returns the url to access the repository with the auth, the http urls are accessed
+//through gitHTTPTransport when the auth carries an http client
+func gitURL(url string, auth transport.AuthMethod) string {
+	if _, ok := auth.(*gitClientAuth); !ok {
+		return url
+	}
+
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return gitClientScheme + url
+	}
+
+	return url
+}
+
+//gitClientAuth carries the http client configured with the TLS and proxy settings of the repository
+//along with the authentication of the requests
+type gitClientAuth struct {
+	client *http.Client
+	auth   transport.AuthMethod
+}
+
+func (a *gitClientAuth) Name() string {
+	return "http-client"
+}
+
+func (a *gitClientAuth) String() string {
+	if a.auth == nil {
+		return a.Name()
+	}
+
+	return fmt.Sprintf("%s - %s", a.Name(), a.auth)
+}
+
+//gitHTTPTransport serves the urls of the private scheme with the http client of the gitClientAuth,
+//the default one otherwise
+type gitHTTPTransport struct{}
+
+func (gitHTTPTransport) transport(ep *transport.Endpoint, auth transport.AuthMethod) (transport.Transport, *transport.Endpoint, transport.AuthMethod) {
+	httpEndpoint := *ep
+	httpEndpoint.Protocol = strings.TrimPrefix(ep.Protocol, gitClientScheme)
+
+	if a, ok := auth.(*gitClientAuth); ok {
+		return githttp.NewClient(a.client), &httpEndpoint, a.auth
+	}
+
+	return githttp.DefaultClient, &httpEndpoint, auth
+}
+
+func (t gitHTTPTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	tr, ep, auth := t.transport(ep, auth)
+	return tr.NewUploadPackSession(ep, auth)
+}
+
+func (t gitHTTPTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	tr, ep, auth := t.transport(ep, auth)
+	return tr.NewReceivePackSession(ep, auth)
+}
+
+//IsSSHGitURL returns true for ssh:// and scp like git@<host>:<path> urls
+func IsSSHGitURL(url string) bool {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return false
+	}
+
+	return ep.Protocol == "ssh"
+}
+
+//SameRepositoryURL returns true if the urls designate the same repository whatever the protocol,
+//the credentials, the port, the case and the .git suffix of the urls
+func SameRepositoryURL(url1 string, url2 string) bool {
+	return normalizeRepositoryURL(url1) == normalizeRepositoryURL(url2)
+}
+
+//normalizeRepositoryURL returns <host>/<path> without the .git suffix, in lower case
+func normalizeRepositoryURL(url string) string {
+	url = strings.TrimSpace(url)
+
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return strings.ToLower(strings.TrimSuffix(url, "/"))
+	}
+
+	path := strings.Trim(ep.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+
+	return strings.ToLower(ep.Host + "/" + path)
+}
+
+//getGitAuth returns the authentication method for the url, ssh public keys for ssh urls
+//and basic authentication for http urls. The http urls are accessed with the TLS and proxy
+//settings of the configMap and the client certificate of the secret.
+func getGitAuth(configMap *corev1.ConfigMap, secret *corev1.Secret, url string) (transport.AuthMethod, error) {
+	if !IsSSHGitURL(url) {
+		var auth transport.AuthMethod
+
+		if secret != nil && secret.Data != nil && (secret.Data["user"] != nil || GetAccessToken(secret) != "") {
+			klog.V(5).Info("Add credentials")
+
+			auth = &githttp.BasicAuth{
+				Username: string(secret.Data["user"]),
+				Password: GetAccessToken(secret),
+			}
+		}
+
+		if ep, err := transport.NewEndpoint(url); err != nil || (ep.Protocol != "http" && ep.Protocol != "https") ||
+			!hasHTTPClientSettings(configMap, secret) {
+			return auth, nil
+		}
+
+		httpClient, err := getHTTPClient(url, configMap, secret)
+		if err != nil {
+			return nil, &GitAuthError{URL: url, Err: err}
+		}
+
+		klog.V(5).Info("Add http client settings")
+
+		return &gitClientAuth{
+			client: httpClient,
+			auth:   auth,
+		}, nil
+	}
+
+	if secret == nil || secret.Data == nil {
+		return nil, &GitAuthError{URL: url, Err: fmt.Errorf("a secret with the %s and %s is required for ssh", SSHKeySecretKey, KnownHostsSecretKey)}
+	}
+
+	key, ok := secret.Data[SSHKeySecretKey]
+	if !ok {
+		return nil, &GitAuthError{URL: url, Err: fmt.Errorf("%s not found in secret %s", SSHKeySecretKey, secret.Name)}
+	}
+
+	hostKeyCallback, err := getKnownHostsCallback(secret.Data[KnownHostsSecretKey])
+	if err != nil {
+		return nil, &GitAuthError{URL: url, Err: err}
+	}
+
+	user := "git"
+	if ep, err := transport.NewEndpoint(url); err == nil && ep.User != "" {
+		user = ep.User
+	}
+
+	klog.V(5).Info("Add ssh credentials for user ", user)
+
+	auth, err := gitssh.NewPublicKeys(user, key, string(secret.Data[SSHKeyPassphraseSecretKey]))
+	if err != nil {
+		return nil, &GitAuthError{URL: url, Err: err}
+	}
+
+	auth.HostKeyCallback = hostKeyCallback
+
+	return auth, nil
+}
+
+//getKnownHostsCallback builds a strict host key callback from the known_hosts entries,
+//a host which is not listed is rejected.
+func getKnownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	if len(strings.TrimSpace(string(knownHosts))) == 0 {
+		return nil, fmt.Errorf("%s is required for strict host key checking", KnownHostsSecretKey)
+	}
+
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(knownHosts)
+	f.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return knownhosts.New(f.Name())
+}
+
+//wrapGitError returns a GitAuthError if the error is an authentication or host key error
+func wrapGitError(url string, err error) error {
+	var authErr *GitAuthError
+
+	if err == nil || errors.As(err, &authErr) || !isGitAuthError(err) {
+		return err
+	}
+
+	return &GitAuthError{URL: url, Err: err}
+}
+
+func isGitAuthError(err error) bool {
+	var keyErr *knownhosts.KeyError
+
+	var revokedErr *knownhosts.RevokedError
+
+	switch {
+	case errors.As(err, &keyErr), errors.As(err, &revokedErr):
+		return true
+	case err == transport.ErrAuthenticationRequired, err == transport.ErrAuthorizationFailed:
+		return true
+	default:
+		//the ssh handshake errors are not wrapped
+		return strings.Contains(err.Error(), "unable to authenticate") || strings.Contains(err.Error(), "knownhosts:")
+	}
+}
+
+//GetGitRemoteHash returns the hash advertised by the first reachable url for the revision,
+//the references are listed without cloning the repository.
+func GetGitRemoteHash(ctx context.Context, configMap *corev1.ConfigMap, secret *corev1.Secret, urls []string, revision GitRevision) (hash string, err error) {
+	err = revision.validate()
+	if err != nil {
+		return "", err
+	}
+
+	if revision.Commit != "" {
+		return revision.Commit, nil
+	}
+
+	target := revision.referenceName()
+
+	for _, url := range urls {
+		auth, errAuth := getGitAuth(configMap, secret, url)
+		if errAuth != nil {
+			err = errAuth
+			continue
+		}
+
+		remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+			Name: git.DefaultRemoteName,
+			URLs: []string{gitURL(url, auth)},
+		})
+
+		refs, errList := listRemote(ctx, remote, auth)
+		if errList != nil {
+			klog.Error(errList, " - List remote references failed: ", url)
+			err = wrapGitError(url, errList)
+
+			continue
+		}
+
+		for _, ref := range refs {
+			if ref.Name() == target {
+				return ref.Hash().String(), nil
+			}
+		}
+
+		err = fmt.Errorf("reference %s not found at %s", target, url)
+	}
+
+	return "", err
+}
+
+//gitWorkingCopyError is returned when the working copy can't be updated, it is cloned again
+type gitWorkingCopyError struct {
+	dir string
+	err error
+}
+
+func (e *gitWorkingCopyError) Error() string {
+	return fmt.Sprintf("working copy %s is unusable: %v", e.dir, e.err)
+}
+
+func (e *gitWorkingCopyError) Unwrap() error {
+	return e.err
+}
+
+//workingCopyError returns a gitWorkingCopyError if the error is caused by a corrupted working copy,
+//the other errors, the transport and authentication errors for example, are returned as they are
+func workingCopyError(dir string, err error) error {
+	if err != nil && isGitCorruption(err) {
+		return &gitWorkingCopyError{dir: dir, err: err}
+	}
+
+	return err
+}
+
+//isGitCorruption returns true if the error is raised by a missing or invalid object of the repository
+func isGitCorruption(err error) bool {
+	for _, corruption := range []error{
+		plumbing.ErrObjectNotFound,
+		plumbing.ErrInvalidType,
+		packfile.ErrReferenceDeltaNotFound,
+		packfile.ErrInvalidDelta,
+		packfile.ErrDeltaCmd,
+		zlib.ErrHeader,
+		zlib.ErrChecksum,
+		zlib.ErrDictionary,
+	} {
+		if errors.Is(err, corruption) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//fetchGitRepo updates the working copy in destRepo to the revision. It returns a gitWorkingCopyError
+//if destRepo is not a valid clone of the url, the errors of the remote are returned as they are.
+func fetchGitRepo(ctx context.Context, destRepo string, url string, revision GitRevision, auth transport.AuthMethod) (string, error) {
+	r, err := git.PlainOpen(destRepo)
+	if err != nil {
+		return "", &gitWorkingCopyError{dir: destRepo, err: err}
+	}
+
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", &gitWorkingCopyError{dir: destRepo, err: err}
+	}
+
+	//The working copy is cloned again if the http client settings are added or removed
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != gitURL(url, auth) {
+		return "", &gitWorkingCopyError{dir: destRepo, err: fmt.Errorf("not a clone of %s", url)}
+	}
+
+	if revision.Commit != "" {
+		hash := plumbing.NewHash(revision.Commit)
+
+		//A commit never changes, fetch only if it is not yet known
+		if _, err := r.CommitObject(hash); err != nil {
+			err = r.FetchContext(ctx, &git.FetchOptions{
+				RemoteName: git.DefaultRemoteName,
+				RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+				Auth:       auth,
+				Force:      true,
+			})
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				return "", workingCopyError(destRepo, err)
+			}
+
+			//The commit is not in the branches of the remote, the working copy is fine
+			if _, err := r.CommitObject(hash); err == plumbing.ErrObjectNotFound {
+				return "", fmt.Errorf("commit %s not found at %s", revision.Commit, url)
+			}
+		}
+
+		commitID, err := checkoutCommit(ctx, r, hash, auth)
+
+		return commitID, workingCopyError(destRepo, err)
+	}
+
+	target := revision.referenceName()
+	if target.IsBranch() {
+		target = plumbing.NewRemoteReferenceName(git.DefaultRemoteName, target.Short())
+	}
+
+	refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", revision.referenceName(), target))
+
+	err = r.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Depth:      1,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", workingCopyError(destRepo, err)
+	}
+
+	//The reference was fetched, it is missing only if the working copy is corrupted
+	ref, err := r.Reference(target, true)
+	if err != nil {
+		return "", &gitWorkingCopyError{dir: destRepo, err: err}
+	}
+
+	hash := ref.Hash()
+
+	if tag, err := r.TagObject(hash); err == nil {
+		c, err := tag.Commit()
+		if err != nil {
+			return "", workingCopyError(destRepo, err)
+		}
+
+		hash = c.Hash
+	}
+
+	commitID, err := checkoutCommit(ctx, r, hash, auth)
+
+	return commitID, workingCopyError(destRepo, err)
+}
+
+//cloneGitRepo clones the repository into a temporary directory next to destRepo and replaces destRepo
+//with it once the revision is checked out, the previous working copy is kept if the clone fails.
+func cloneGitRepo(ctx context.Context, destRepo string, options *git.CloneOptions, revision GitRevision) (commitID string, err error) {
+	parent := filepath.Dir(destRepo)
+
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	tmpRepo, err := ioutil.TempDir(parent, "."+filepath.Base(destRepo)+"-clone")
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(tmpRepo)
+
+	cloneOptions := *options
+	cloneOptions.URL = gitURL(options.URL, options.Auth)
+	//The submodules are updated by updateSubmodules to route their urls as well
+	cloneOptions.RecurseSubmodules = git.NoRecurseSubmodules
+
+	r, err := git.PlainCloneContext(ctx, tmpRepo, false, &cloneOptions)
+	if err != nil {
+		return "", err
+	}
+
+	if revision.Commit != "" {
+		_, err = checkoutCommit(ctx, r, plumbing.NewHash(revision.Commit), options.Auth)
+		if err != nil {
+			return "", fmt.Errorf("checkout of %s failed: %w", revision.Commit, err)
+		}
+	} else if options.RecurseSubmodules != git.NoRecurseSubmodules {
+		err = updateSubmodules(ctx, r, options.Auth, options.RecurseSubmodules)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	commitID, err = headCommit(r)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.RemoveAll(destRepo)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpRepo, destRepo)
+	if err != nil {
+		return "", err
+	}
+
+	return commitID, nil
+}
+
+//listRemote lists the references of the remote, go-git has no context aware listing so
+//the listing is abandoned when the context is cancelled and ends with the transport timeouts.
+func listRemote(ctx context.Context, remote *git.Remote, auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	type listResult struct {
+		refs []*plumbing.Reference
+		err  error
+	}
+
+	result := make(chan listResult, 1)
+
+	go func() {
+		refs, err := remote.List(&git.ListOptions{Auth: auth})
+		result <- listResult{refs: refs, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		return res.refs, res.err
+	}
+}
+
+//checkoutCommit checks out the commit, removes the untracked files and updates the submodules accordingly
+func checkoutCommit(ctx context.Context, r *git.Repository, hash plumbing.Hash, auth transport.AuthMethod) (string, error) {
+	w, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	err = w.Checkout(&git.CheckoutOptions{
+		Hash:  hash,
+		Force: true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = w.Clean(&git.CleanOptions{Dir: true})
+	if err != nil {
+		return "", err
+	}
+
+	err = updateSubmodules(ctx, r, auth, git.DefaultSubmoduleRecursionDepth)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.String(), nil
+}
+
+//updateSubmodules initializes and updates the submodules up to the depth, the recursion is done here
+//and not by go-git so that the urls of the nested submodules are routed by gitURL too.
+func updateSubmodules(ctx context.Context, r *git.Repository, auth transport.AuthMethod, depth git.SubmoduleRescursivity) error {
+	if depth == git.NoRecurseSubmodules {
+		return nil
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	submodules, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		submodule.Config().URL = gitURL(submodule.Config().URL, auth)
+
+		err = submodule.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.NoRecurseSubmodules,
+			Auth:              auth,
+		})
+		if err != nil {
+			return err
+		}
+
+		subRepo, err := submodule.Repository()
+		if err != nil {
+			return err
+		}
+
+		err = updateSubmodules(ctx, subRepo, auth, depth-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//gitTreeHash returns the hash of the tree at path in the commit, it changes only if
+//a file under the path changes.
+func gitTreeHash(destRepo string, commitID string, path string) (string, error) {
+	r, err := git.PlainOpen(destRepo)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := r.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	path = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+	if path == "" || path == "." {
+		return tree.Hash.String(), nil
+	}
+
+	subTree, err := tree.Tree(path)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in commit %s: %v", path, commitID, err)
+	}
+
+	return subTree.Hash.String(), nil
+}
+
+//headCommit returns the commit of HEAD, resolving the annotated tags
+func headCommit(r *git.Repository) (string, error) {
+	h, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+
+	if tag, err := r.TagObject(h.Hash()); err == nil {
+		c, err := tag.Commit()
+		if err != nil {
+			return "", err
+		}
+
+		return c.Hash.String(), nil
+	}
+
+	return h.Hash().String(), nil
+}
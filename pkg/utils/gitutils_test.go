@@ -0,0 +1,406 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newSSHSecret(t *testing.T) *corev1.Secret {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	hostPublicKey, err := ssh.NewPublicKey(&hostKey.PublicKey)
+	assert.NoError(t, err)
+
+	return &corev1.Secret{
+		Data: map[string][]byte{
+			SSHKeySecretKey:     privateKey,
+			KnownHostsSecretKey: []byte(knownhosts.Line([]string{"git.example.com"}, hostPublicKey)),
+		},
+	}
+}
+
+func TestIsSSHGitURL(t *testing.T) {
+	assert.True(t, IsSSHGitURL("ssh://git@git.example.com/org/repo.git"))
+	assert.True(t, IsSSHGitURL("git@git.example.com:org/repo.git"))
+	assert.False(t, IsSSHGitURL("https://github.com/IBM/multicloud-operators-subscription-release.git"))
+}
+
+func TestSameRepositoryURL(t *testing.T) {
+	assert.True(t, SameRepositoryURL("https://github.com/IBM/multicloud-operators-subscription-release.git",
+		"https://github.com/ibm/multicloud-operators-subscription-release"))
+	assert.True(t, SameRepositoryURL("https://user@git.example.com:8443/org/repo/", "git@git.example.com:org/repo.git"))
+	assert.True(t, SameRepositoryURL("ssh://git@git.example.com/org/repo.git", "git@git.example.com:org/repo.git"))
+	assert.True(t, SameRepositoryURL("https://charts.example.com/", "http://charts.example.com"))
+	assert.False(t, SameRepositoryURL("https://git.example.com/org/repo", "https://git.example.com/org/other-repo"))
+	assert.False(t, SameRepositoryURL("https://git.example.com/org/repo", "https://other.example.com/org/repo"))
+}
+
+func TestGitURL(t *testing.T) {
+	url := "https://git.example.com/org/repo.git"
+	basicAuth := &githttp.BasicAuth{Username: "user", Password: "password"}
+	clientAuth := &gitClientAuth{client: &http.Client{Timeout: time.Minute}, auth: basicAuth}
+
+	assert.Equal(t, url, gitURL(url, nil))
+	assert.Equal(t, url, gitURL(url, basicAuth))
+	assert.Equal(t, "client+"+url, gitURL(url, clientAuth))
+	assert.Equal(t, "git@git.example.com:org/repo.git", gitURL("git@git.example.com:org/repo.git", clientAuth))
+
+	ep, err := transport.NewEndpoint(gitURL(url, clientAuth))
+	assert.NoError(t, err)
+	assert.Equal(t, "client+https", ep.Protocol)
+
+	tr, httpEndpoint, auth := gitHTTPTransport{}.transport(ep, clientAuth)
+	assert.NotEqual(t, githttp.DefaultClient, tr)
+	assert.Equal(t, url, httpEndpoint.String())
+	assert.Equal(t, basicAuth, auth)
+
+	//The transports of the other go-git users are untouched
+	assert.Equal(t, githttp.DefaultClient, client.Protocols["https"])
+	assert.Equal(t, githttp.DefaultClient, client.Protocols["http"])
+}
+
+func TestGetGitAuth(t *testing.T) {
+	auth, err := getGitAuth(nil, nil, "https://github.com/IBM/multicloud-operators-subscription-release.git")
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			"user":        []byte("user"),
+			"accessToken": []byte("token"),
+		},
+	}
+	auth, err = getGitAuth(nil, secret, "https://github.com/IBM/multicloud-operators-subscription-release.git")
+	assert.NoError(t, err)
+	assert.Equal(t, &githttp.BasicAuth{Username: "user", Password: "token"}, auth)
+
+	_, err = getGitAuth(nil, secret, "git@git.example.com:org/repo.git")
+	assert.Error(t, err)
+	assert.True(t, isGitAuthError(err))
+
+	secret = newSSHSecret(t)
+	auth, err = getGitAuth(nil, secret, "git@git.example.com:org/repo.git")
+	assert.NoError(t, err)
+
+	publicKeys, ok := auth.(*gitssh.PublicKeys)
+	assert.True(t, ok)
+	assert.Equal(t, "git", publicKeys.User)
+	assert.NotNil(t, publicKeys.HostKeyCallback)
+
+	delete(secret.Data, KnownHostsSecretKey)
+	_, err = getGitAuth(nil, secret, "ssh://deploy@git.example.com/org/repo.git")
+	assert.Error(t, err)
+}
+
+func TestWrapGitError(t *testing.T) {
+	assert.Nil(t, wrapGitError("https://git.example.com/repo.git", nil))
+
+	err := fmt.Errorf("repository not found")
+	assert.Equal(t, err, wrapGitError("https://git.example.com/repo.git", err))
+
+	err = wrapGitError("https://git.example.com/repo.git", transport.ErrAuthenticationRequired)
+	_, ok := err.(*GitAuthError)
+	assert.True(t, ok)
+
+	err = wrapGitError("git@git.example.com:repo.git", fmt.Errorf("ssh: handshake failed: knownhosts: key is unknown"))
+	_, ok = err.(*GitAuthError)
+	assert.True(t, ok)
+	assert.Equal(t, err, wrapGitError("git@git.example.com:repo.git", err))
+}
+
+//newLocalGitRepo creates a repository with 2 commits, the first one tagged v0.1.0
+func newLocalGitRepo(t *testing.T, dir string) (first, second string) {
+	r, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	signature := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+
+	for _, version := range []string{"0.1.0", "0.2.0"} {
+		err = ioutil.WriteFile(filepath.Join(dir, "VERSION"), []byte(version), 0644)
+		assert.NoError(t, err)
+
+		_, err = w.Add("VERSION")
+		assert.NoError(t, err)
+
+		h, err := w.Commit(version, &git.CommitOptions{Author: signature})
+		assert.NoError(t, err)
+
+		if version == "0.1.0" {
+			first = h.String()
+
+			_, err = r.CreateTag("v0.1.0", h, &git.CreateTagOptions{Tagger: signature, Message: version})
+			assert.NoError(t, err)
+		} else {
+			second = h.String()
+		}
+	}
+
+	return first, second
+}
+
+func TestDownloadGitHubRepoRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	first, second := newLocalGitRepo(t, source)
+	urls := []string{"file://" + source}
+	destRepo := filepath.Join(dir, "test")
+
+	commitID, err := DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{})
+	assert.NoError(t, err)
+	assert.Equal(t, second, commitID)
+
+	commitID, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{Tag: "v0.1.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, first, commitID)
+
+	version, err := ioutil.ReadFile(filepath.Join(destRepo, "VERSION"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1.0", string(version))
+
+	commitID, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{Branch: "master", Commit: first})
+	assert.NoError(t, err)
+	assert.Equal(t, first, commitID)
+
+	version, err = ioutil.ReadFile(filepath.Join(destRepo, "VERSION"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1.0", string(version))
+
+	_, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{Commit: "1234"})
+	assert.Error(t, err)
+}
+
+func TestDownloadGitHubRepoReuse(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	_, second := newLocalGitRepo(t, source)
+	urls := []string{"file://" + source}
+	destRepo := filepath.Join(dir, "test")
+
+	commitID, err := DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{})
+	assert.NoError(t, err)
+	assert.Equal(t, second, commitID)
+
+	//The marker survives only if the working copy is fetched instead of cloned
+	marker := filepath.Join(destRepo, ".git", "marker")
+	err = ioutil.WriteFile(marker, []byte("marker"), 0644)
+	assert.NoError(t, err)
+
+	r, err := git.PlainOpen(source)
+	assert.NoError(t, err)
+
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(source, "VERSION"), []byte("0.3.0"), 0644)
+	assert.NoError(t, err)
+
+	_, err = w.Add("VERSION")
+	assert.NoError(t, err)
+
+	third, err := w.Commit("0.3.0", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	commitID, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{})
+	assert.NoError(t, err)
+	assert.Equal(t, third.String(), commitID)
+
+	_, err = os.Stat(marker)
+	assert.NoError(t, err)
+
+	version, err := ioutil.ReadFile(filepath.Join(destRepo, "VERSION"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.3.0", string(version))
+
+	//The working copy is kept when the clone of another url fails
+	_, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, []string{"file://" + filepath.Join(dir, "missing")}, GitRevision{})
+	assert.Error(t, err)
+
+	version, err = ioutil.ReadFile(filepath.Join(destRepo, "VERSION"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.3.0", string(version))
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	//An unreachable remote fails the update without cloning again
+	err = os.Rename(source, source+"-moved")
+	assert.NoError(t, err)
+
+	_, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{})
+	assert.Error(t, err)
+
+	_, err = os.Stat(marker)
+	assert.NoError(t, err)
+
+	err = os.Rename(source+"-moved", source)
+	assert.NoError(t, err)
+
+	//A corrupted working copy is cloned again
+	err = os.Remove(filepath.Join(destRepo, ".git", "HEAD"))
+	assert.NoError(t, err)
+
+	commitID, err = DownloadGitHubRepo(context.TODO(), nil, nil, destRepo, urls, GitRevision{})
+	assert.NoError(t, err)
+	assert.Equal(t, third.String(), commitID)
+
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGitWorkingCopyDir(t *testing.T) {
+	urls := []string{"https://github.com/IBM/multicloud-operators-subscription-release.git"}
+
+	dir := GitWorkingCopyDir("/charts", urls, GitRevision{Branch: "master"})
+	assert.Equal(t, filepath.Join("/charts", GitWorkingCopyDirName), filepath.Dir(dir))
+
+	//The subscriptions to the same repository and revision share the working copy
+	assert.Equal(t, dir, GitWorkingCopyDir("/charts", urls, GitRevision{Branch: "master"}))
+	assert.NotEqual(t, dir, GitWorkingCopyDir("/charts", urls, GitRevision{Tag: "master"}))
+	assert.NotEqual(t, dir, GitWorkingCopyDir("/charts", append(urls, "https://example.com/charts.git"), GitRevision{Branch: "master"}))
+}
+
+func TestGetGitRemoteHash(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	first, second := newLocalGitRepo(t, source)
+	urls := []string{"file://" + source}
+
+	hash, err := GetGitRemoteHash(context.TODO(), nil, nil, urls, GitRevision{})
+	assert.NoError(t, err)
+	assert.Equal(t, second, hash)
+
+	r, err := git.PlainOpen(source)
+	assert.NoError(t, err)
+
+	tag, err := r.Tag("v0.1.0")
+	assert.NoError(t, err)
+
+	hash, err = GetGitRemoteHash(context.TODO(), nil, nil, urls, GitRevision{Tag: "v0.1.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Hash().String(), hash)
+
+	hash, err = GetGitRemoteHash(context.TODO(), nil, nil, urls, GitRevision{Commit: first})
+	assert.NoError(t, err)
+	assert.Equal(t, first, hash)
+
+	_, err = GetGitRemoteHash(context.TODO(), nil, nil, urls, GitRevision{Branch: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestGitTreeHash(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	first, _ := newLocalGitRepo(t, source)
+
+	r, err := git.PlainOpen(source)
+	assert.NoError(t, err)
+
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	signature := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+
+	err = os.MkdirAll(filepath.Join(source, "charts"), 0755)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(source, "charts", "README"), []byte("charts"), 0644)
+	assert.NoError(t, err)
+
+	_, err = w.Add("charts/README")
+	assert.NoError(t, err)
+
+	third, err := w.Commit("charts", &git.CommitOptions{Author: signature})
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(source, "VERSION"), []byte("0.3.0"), 0644)
+	assert.NoError(t, err)
+
+	_, err = w.Add("VERSION")
+	assert.NoError(t, err)
+
+	fourth, err := w.Commit("0.3.0", &git.CommitOptions{Author: signature})
+	assert.NoError(t, err)
+
+	//A commit outside of the path doesn't change the hash
+	hash3, err := gitTreeHash(source, third.String(), "/charts/")
+	assert.NoError(t, err)
+
+	hash4, err := gitTreeHash(source, fourth.String(), "charts")
+	assert.NoError(t, err)
+	assert.Equal(t, hash3, hash4)
+
+	root3, err := gitTreeHash(source, third.String(), "")
+	assert.NoError(t, err)
+
+	root4, err := gitTreeHash(source, fourth.String(), ".")
+	assert.NoError(t, err)
+	assert.NotEqual(t, root3, root4)
+
+	_, err = gitTreeHash(source, first, "charts")
+	assert.Error(t, err)
+}
@@ -17,29 +17,28 @@ limitations under the License.
 package utils
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"bytes"
+	"context"
 	"crypto/sha1"
-	"crypto/tls"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/ghodss/yaml"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
-	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/helm/pkg/chartutil"
@@ -50,60 +49,20 @@ import (
 	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
 )
 
-//GetHelmRepoClient returns an *http.client to access the helm repo
-func GetHelmRepoClient(parentNamespace string, configMap *corev1.ConfigMap) (rest.HTTPClient, error) {
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: false,
-		},
-	}
-
-	if configMap != nil {
-		configData := configMap.Data
-		klog.V(5).Info("ConfigRef retrieved :", configData)
-		insecureSkipVerify := configData["insecureSkipVerify"]
-
-		if insecureSkipVerify != "" {
-			b, err := strconv.ParseBool(insecureSkipVerify)
-			if err != nil {
-				if errors.IsNotFound(err) {
-					return nil, nil
-				}
-
-				klog.Error(err, " - Unable to parse insecureSkipVerify", insecureSkipVerify)
-
-				return nil, err
-			}
-
-			klog.V(5).Info("Set InsecureSkipVerify: ", b)
-			transport.TLSClientConfig.InsecureSkipVerify = b
-		} else {
-			klog.V(5).Info("insecureSkipVerify is not specified")
-		}
-	} else {
-		klog.V(5).Info("configMap is nil")
-	}
-
-	httpClient := http.DefaultClient
-	httpClient.Transport = transport
-	klog.V(5).Info("InsecureSkipVerify equal ", transport.TLSClientConfig.InsecureSkipVerify)
-
-	return httpClient, nil
+//GetHelmRepoClient returns an *http.client to access the helm repo, the TLS settings and the timeouts
+//are taken from the configMap and the client certificate from the secret. The clients are pooled by
+//settings and reused across the calls, the repositories accessed internally have their own clients.
+func GetHelmRepoClient(parentNamespace string, configMap *corev1.ConfigMap, secret *corev1.Secret) (rest.HTTPClient, error) {
+	return httpClients.get("", configMap, secret)
 }
 
-//DownloadChart downloads the charts
-func DownloadChart(configMap *corev1.ConfigMap,
+//DownloadChart downloads the charts with the ChartDownloader registered for the source type,
+//the keyringSecret is required to verify the chart signature. The download is aborted when the
+//context is cancelled.
+func DownloadChart(ctx context.Context,
+	configMap *corev1.ConfigMap,
 	secret *corev1.Secret,
+	keyringSecret *corev1.Secret,
 	chartsDir string,
 	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
 	destRepo := filepath.Join(chartsDir, s.Spec.ReleaseName, s.Namespace, s.Spec.ChartName)
@@ -115,39 +74,102 @@ func DownloadChart(configMap *corev1.ConfigMap,
 		}
 	}
 
-	switch strings.ToLower(string(s.Spec.Source.SourceType)) {
-	case string(appv1alpha1.HelmRepoSourceType):
-		return DownloadChartFromHelmRepo(configMap, secret, destRepo, s)
-	case string(appv1alpha1.GitHubSourceType):
-		return DownloadChartFromGitHub(configMap, secret, destRepo, s)
-	default:
+	downloader, ok := getChartDownloader(s.Spec.Source.SourceType)
+	if !ok {
 		return "", fmt.Errorf("sourceType '%s' unsupported", s.Spec.Source.SourceType)
 	}
+
+	if IsProvenanceVerificationRequired(s.Spec.Verification) && !downloader.verifiesProvenance {
+		return "", &ChartVerificationError{
+			URL: s.Spec.Source.String(),
+			Err: fmt.Errorf("provenance verification is not supported for sourceType '%s'", s.Spec.Source.SourceType),
+		}
+	}
+
+	return downloader.download(ctx, configMap, secret, keyringSecret, chartsDir, destRepo, s)
+}
+
+//chartsDirMutex serializes the creation of the temporary charts directory
+var chartsDirMutex sync.Mutex
+
+//GetChartsDir returns the directory of the charts set in CHARTS_DIR, if it is not set a temporary directory
+//is created once and recorded in CHARTS_DIR so that the downloads, the cache and the cleanup use the same one.
+func GetChartsDir() (string, error) {
+	chartsDirMutex.Lock()
+	defer chartsDirMutex.Unlock()
+
+	if chartsDir := os.Getenv(appv1alpha1.ChartsDir); chartsDir != "" {
+		return chartsDir, nil
+	}
+
+	chartsDir, err := ioutil.TempDir("/tmp", "charts")
+	if err != nil {
+		klog.Error(err, " - Can not create tempdir")
+		return "", err
+	}
+
+	return chartsDir, os.Setenv(appv1alpha1.ChartsDir, chartsDir)
+}
+
+//DeleteChartsDir removes the charts downloaded for the helmrelease, the charts cache is kept.
+//The charts directory is resolved with GetChartsDir if chartsDir is empty.
+func DeleteChartsDir(chartsDir string, s *appv1alpha1.HelmRelease) error {
+	if s.Spec.ReleaseName == "" {
+		return nil
+	}
+
+	if chartsDir == "" {
+		var err error
+
+		chartsDir, err = GetChartsDir()
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(filepath.Join(chartsDir, s.Spec.ReleaseName, s.Namespace))
 }
 
 //DownloadChartFromGitHub downloads a chart into the charsDir
-func DownloadChartFromGitHub(configMap *corev1.ConfigMap, secret *corev1.Secret, destRepo string, s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+func DownloadChartFromGitHub(ctx context.Context, configMap *corev1.ConfigMap, secret *corev1.Secret, destRepo string, s *appv1alpha1.HelmRelease) (chartDir string, err error) {
 	if s.Spec.Source.GitHub == nil {
 		err := fmt.Errorf("github type but Spec.GitHub is not defined")
 		return "", err
 	}
 
-	_, err = DownloadGitHubRepo(configMap, secret, destRepo, s.Spec.Source.GitHub.Urls, s.Spec.Source.GitHub.Branch)
+	revision := GitRevision{
+		Branch: s.Spec.Source.GitHub.Branch,
+		Tag:    s.Spec.Source.GitHub.Tag,
+		Commit: s.Spec.Source.GitHub.Commit,
+	}
 
+	commitID, err := DownloadGitHubRepo(ctx, configMap, secret, destRepo, s.Spec.Source.GitHub.Urls, revision)
 	if err != nil {
 		return "", err
 	}
 
+	//Record the resolved commit, it is persisted with the helmrelease status
+	s.Status.Commit = commitID
+
 	chartDir = filepath.Join(destRepo, s.Spec.Source.GitHub.ChartPath)
 
 	return chartDir, err
 }
 
-//DownloadGitHubRepo downloads a github repo into the charsDir
-func DownloadGitHubRepo(configMap *corev1.ConfigMap,
+//DownloadGitHubRepo downloads a github repo into the charsDir,
+//an existing working copy of the same url is fetched instead of being cloned again.
+//The working copy is only cloned again if it is missing or corrupted.
+//The clone and the fetch are aborted when the context is cancelled.
+func DownloadGitHubRepo(ctx context.Context,
+	configMap *corev1.ConfigMap,
 	secret *corev1.Secret,
 	destRepo string,
-	urls []string, branch string) (commitID string, err error) {
+	urls []string, revision GitRevision) (commitID string, err error) {
+	err = revision.validate()
+	if err != nil {
+		return "", err
+	}
+
 	for _, url := range urls {
 		options := &git.CloneOptions{
 			URL:               url,
@@ -156,45 +178,52 @@ func DownloadGitHubRepo(configMap *corev1.ConfigMap,
 			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
 		}
 
-		if secret != nil && secret.Data != nil {
-			klog.V(5).Info("Add credentials")
+		auth, errAuth := getGitAuth(configMap, secret, url)
+		if errAuth != nil {
+			klog.Error(errAuth, " - Unable to set the credentials: ", url)
+			err = errAuth
 
-			options.Auth = &githttp.BasicAuth{
-				Username: string(secret.Data["user"]),
-				Password: GetAccessToken(secret),
-			}
-		}
-
-		if branch == "" {
-			options.ReferenceName = plumbing.Master
-		} else {
-			options.ReferenceName = plumbing.ReferenceName("refs/heads/" + branch)
+			continue
 		}
 
-		os.RemoveAll(destRepo)
+		options.Auth = auth
 
-		r, errClone := git.PlainClone(destRepo, false, options)
+		commitID, err = fetchGitRepo(ctx, destRepo, url, revision, auth)
+		if err == nil {
+			klog.V(5).Info("commitID: ", commitID)
+			break
+		}
 
-		if errClone != nil {
-			os.RemoveAll(destRepo)
-			klog.Error(errClone, " - Clone failed: ", url)
-			err = errClone
+		//Only a missing or corrupted working copy is cloned again, not an unreachable remote
+		var workingCopyErr *gitWorkingCopyError
+		if !errors.As(err, &workingCopyErr) {
+			klog.Error(err, " - Fetch failed: ", url)
+			err = wrapGitError(url, err)
 
 			continue
 		}
 
-		h, errHead := r.Head()
+		klog.V(3).Info(err, " - Unable to update the working copy, cloning: ", url)
 
-		if errHead != nil {
-			os.RemoveAll(destRepo)
-			klog.Error(errHead, " - Get Head failed: ", url)
-			err = errHead
+		if revision.Commit != "" {
+			//The commit can be anywhere in the history
+			options.Depth = 0
+			options.SingleBranch = false
+		} else {
+			options.ReferenceName = revision.referenceName()
+		}
+
+		commitID, err = cloneGitRepo(ctx, destRepo, options, revision)
+		if err != nil {
+			klog.Error(err, " - Clone failed: ", url)
+			err = wrapGitError(url, err)
 
 			continue
 		}
 
-		commitID = h.Hash().String()
 		klog.V(5).Info("commitID: ", commitID)
+
+		break
 	}
 
 	if err != nil {
@@ -205,8 +234,27 @@ func DownloadGitHubRepo(configMap *corev1.ConfigMap,
 }
 
 //DownloadChartFromHelmRepo downloads a chart into the charsDir
-func DownloadChartFromHelmRepo(configMap *corev1.ConfigMap,
+func DownloadChartFromHelmRepo(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+	chartsDir, err := GetChartsDir()
+	if err != nil {
+		return "", err
+	}
+
+	//The cache is shared by all the releases
+	cache := NewChartCache(filepath.Join(chartsDir, ChartCacheDirName))
+
+	return downloadChartFromHelmRepo(ctx, configMap, secret, nil, cache, destRepo, s)
+}
+
+func downloadChartFromHelmRepo(ctx context.Context,
+	configMap *corev1.ConfigMap,
 	secret *corev1.Secret,
+	keyringSecret *corev1.Secret,
+	cache *ChartCache,
 	destRepo string,
 	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
 	if s.Spec.Source.HelmRepo == nil {
@@ -214,236 +262,531 @@ func DownloadChartFromHelmRepo(configMap *corev1.ConfigMap,
 		return "", err
 	}
 
-	var downloadErr error
+	chartDir = filepath.Join(destRepo, s.Spec.ChartName)
 
 	for _, urlelem := range s.Spec.Source.HelmRepo.Urls {
-		chartZip, downloadErr := downloadFile(s.Namespace, configMap, urlelem, secret, destRepo)
-		if downloadErr != nil {
-			klog.Error(downloadErr, " - url: ", urlelem)
+		urlSecret := secretForURL(secret, s.Spec.Source.HelmRepo.RepoURL, urlelem)
+
+		if IsProvenanceVerificationRequired(s.Spec.Verification) {
+			err = downloadVerifiedChart(ctx, keyringSecret, cache, s.Namespace, configMap, urlSecret, urlelem, s.Spec.Digest, destRepo, chartDir)
+		} else {
+			err = streamChart(ctx, cache, s.Namespace, configMap, urlSecret, urlelem, s.Spec.Digest, destRepo)
+		}
+
+		if err != nil {
+			klog.Error(err, " - url: ", urlelem)
 			continue
 		}
 
-		var r *os.File
+		return chartDir, nil
+	}
 
-		r, downloadErr = os.Open(chartZip)
-		if downloadErr != nil {
-			klog.Error(downloadErr, " - Failed to open: ", chartZip)
-			continue
+	return "", err
+}
+
+//downloadVerifiedChart downloads the chart archive into the cache and verifies its provenance
+//before extracting it, the archive must be on disk as the signature covers the whole archive.
+func downloadVerifiedChart(ctx context.Context,
+	keyringSecret *corev1.Secret,
+	cache *ChartCache,
+	parentNamespace string,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	fileURL string,
+	digest string,
+	destRepo string,
+	chartDir string) error {
+	chartZip, err := downloadChartToCache(ctx, cache, parentNamespace, configMap, secret, fileURL, digest)
+	if err != nil {
+		return err
+	}
+
+	defer cache.Release(chartZip)
+
+	//The provenance file is private to the download, the cache entry is shared by the releases
+	provDir, err := ioutil.TempDir("", "prov")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(provDir)
+
+	provFile, err := downloadFile(ctx, parentNamespace, configMap, fileURL+".prov", secret, provDir)
+	if err == nil {
+		err = verifyChartProvenance(keyringSecret, fileURL, chartZip, provFile)
+	} else {
+		err = &ChartVerificationError{URL: fileURL, Err: fmt.Errorf("unable to retrieve the provenance file: %v", err)}
+	}
+
+	if err != nil {
+		klog.Error(err, " - Refusing to install ", chartZip)
+		return err
+	}
+
+	return untarCachedChart(cache, chartZip, destRepo)
+}
+
+//untarCachedChart extracts a chart archive of the cache, the archive is removed from the cache if it can't be extracted
+func untarCachedChart(cache *ChartCache, chartZip string, destRepo string) error {
+	r, err := os.Open(chartZip)
+	if err != nil {
+		klog.Error(err, " - Failed to open: ", chartZip)
+		return err
+	}
+
+	defer r.Close()
+
+	//The previous chart is replaced once the archive is extracted
+	err = Untar(destRepo, r)
+	if err != nil {
+		//Remove zip because failed to untar and so probably corrupted
+		cache.Remove(chartZip)
+		klog.Error(err, "- Failed to unzip: ", chartZip)
+
+		return err
+	}
+
+	return nil
+}
+
+//streamChart extracts the chart archive while it is downloaded and hashed, the archive is
+//written to the cache only when the digest is known as the cache is looked up by digest.
+//The chart is extracted into a temporary directory next to destRepo and only moved into
+//destRepo once the archive matches the digest, the previous chart is kept otherwise.
+func streamChart(ctx context.Context,
+	cache *ChartCache,
+	parentNamespace string,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	fileURL string,
+	digest string,
+	destRepo string) error {
+	fileName, err := fileNameFromURL(fileURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = streamChartArchive(cache, func() (io.ReadCloser, error) {
+		return openFile(ctx, parentNamespace, configMap, fileURL, secret)
+	}, fileURL, fileName, digest, destRepo)
+
+	return err
+}
+
+//streamChartArchive streams the archive returned by open into destRepo as streamChart does,
+//the archive is added to the cache as fileName. It returns the name of the chart directory.
+func streamChartArchive(cache *ChartCache,
+	open func() (io.ReadCloser, error),
+	fileURL string,
+	fileName string,
+	digest string,
+	destRepo string) (chartName string, err error) {
+	digest = strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
+
+	if digest != "" {
+		if chartZip, ok := cache.Get(digest); ok {
+			defer cache.Release(chartZip)
+
+			klog.V(3).Info("Chart ", fileURL, " found in cache: ", chartZip)
+
+			chartName, err = cachedChartDir(cache, chartZip)
+			if err != nil {
+				return "", err
+			}
+
+			return chartName, untarCachedChart(cache, chartZip, destRepo)
 		}
+	}
 
-		chartDir = filepath.Join(destRepo, s.Spec.ChartName)
-		//Clean before untar
-		os.RemoveAll(chartDir)
+	body, err := open()
+	if err != nil {
+		return "", err
+	}
 
-		downloadErr = Untar(destRepo, r)
-		if downloadErr != nil {
-			//Remove zip because failed to untar and so probably corrupted
-			os.RemoveAll(chartZip)
-			klog.Error(downloadErr, "- Failed to unzip: ", chartZip)
+	defer body.Close()
 
-			continue
+	h := sha256.New()
+
+	var tmpFile string
+
+	var out *os.File
+
+	if digest != "" {
+		tmpFile, err = cache.TempFile()
+		if err != nil {
+			return "", err
+		}
+
+		//The temp file is moved into the cache on success
+		defer os.Remove(tmpFile)
+
+		out, err = os.Create(tmpFile)
+		if err != nil {
+			return "", err
 		}
+
+		defer out.Close()
+	}
+
+	var w io.Writer = h
+	if out != nil {
+		w = io.MultiWriter(h, out)
+	}
+
+	r := io.TeeReader(body, w)
+
+	err = os.MkdirAll(destRepo, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(filepath.Clean(destRepo)), "."+filepath.Base(destRepo)+"-stream")
+	if err != nil {
+		return "", err
 	}
 
-	return chartDir, downloadErr
+	defer os.RemoveAll(tmpDir)
+
+	err = Untar(tmpDir, r)
+	if err == nil {
+		//Hash the end of the archive not read by the extraction
+		_, err = io.Copy(ioutil.Discard, r)
+	}
+
+	if err != nil {
+		klog.Error(err, "- Failed to unzip: ", fileURL)
+		return "", err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+
+	if digest != "" && digest != actual {
+		return "", &ChartDigestError{URL: fileURL, Expected: digest, Actual: actual}
+	}
+
+	chartName, err = extractedChartDir(tmpDir)
+	if err != nil {
+		return "", err
+	}
+
+	err = moveEntries(tmpDir, destRepo)
+	if err != nil {
+		klog.Error(err, " - Failed to move the chart into ", destRepo)
+		return "", err
+	}
+
+	if out == nil {
+		return chartName, nil
+	}
+
+	err = out.Close()
+	if err == nil {
+		var chartZip string
+
+		chartZip, err = cache.Add(tmpFile, fileName, actual)
+		if err == nil {
+			cache.Release(chartZip)
+		}
+	}
+
+	if err != nil {
+		//The chart is extracted, it will be downloaded again next time
+		klog.Error(err, " - Unable to add ", fileURL, " to the cache")
+	}
+
+	return chartName, nil
+}
+
+//cachedChartDir returns the name of the chart directory of an archive of the cache,
+//the archive is removed from the cache if it can't be read
+func cachedChartDir(cache *ChartCache, chartZip string) (string, error) {
+	r, err := os.Open(chartZip)
+	if err != nil {
+		klog.Error(err, " - Failed to open: ", chartZip)
+		return "", err
+	}
+
+	defer r.Close()
+
+	chartName, err := chartArchiveDir(r)
+	if err != nil {
+		cache.Remove(chartZip)
+		klog.Error(err, " - Failed to read: ", chartZip)
+
+		return "", err
+	}
+
+	return chartName, nil
+}
+
+//ChartDigestError is returned when the sha256 of the downloaded chart archive doesn't match the expected digest
+type ChartDigestError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChartDigestError) Error() string {
+	return fmt.Sprintf("chart digest mismatch for %s: expected sha256 %s, got %s", e.URL, e.Expected, e.Actual)
+}
+
+//ErrDownloadTooLarge the downloaded file exceeds the maximum download size
+var ErrDownloadTooLarge = errors.New("download exceeds the maximum size")
+
+//defaultMaxDownloadSize is the maximum size of a downloaded file when CHARTS_MAX_DOWNLOAD_SIZE is not set
+const defaultMaxDownloadSize int64 = 20 << 20
+
+//fileSHA256 returns the hex encoded sha256 of the file
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 //downloadFile downloads a files and post it in the chartsDir.
-func downloadFile(parentNamespace string, configMap *corev1.ConfigMap,
+func downloadFile(ctx context.Context, parentNamespace string, configMap *corev1.ConfigMap,
 	fileURL string,
 	secret *corev1.Secret,
 	chartsDir string) (string, error) {
 	klog.V(4).Info("fileURL: ", fileURL)
 
-	URLP, downloadErr := url.Parse(fileURL)
+	fileName, downloadErr := fileNameFromURL(fileURL)
 	if downloadErr != nil {
-		klog.Error(downloadErr, " - url:", fileURL)
 		return "", downloadErr
 	}
 
-	fileName := filepath.Base(URLP.RequestURI())
-	klog.V(4).Info("fileName: ", fileName)
 	// Create the file
 	chartZip := filepath.Join(chartsDir, fileName)
 	klog.V(4).Info("chartZip: ", chartZip)
 
-	switch URLP.Scheme {
-	case "file":
-		downloadErr = downloadFileLocal(URLP, chartZip)
-	case "http", "https":
-		downloadErr = downloadFileHTTP(parentNamespace, configMap, fileURL, secret, chartZip)
-	default:
-		downloadErr = fmt.Errorf("unsupported scheme %s", URLP.Scheme)
+	return chartZip, fetchFile(ctx, parentNamespace, configMap, fileURL, secret, chartZip)
+}
+
+//fileNameFromURL returns the last element of the url path
+func fileNameFromURL(fileURL string) (string, error) {
+	URLP, err := url.Parse(fileURL)
+	if err != nil {
+		klog.Error(err, " - url:", fileURL)
+		return "", err
 	}
 
-	return chartZip, downloadErr
+	fileName := filepath.Base(URLP.RequestURI())
+	klog.V(4).Info("fileName: ", fileName)
+
+	return fileName, nil
 }
 
-func downloadFileLocal(urlP *url.URL,
-	chartZip string) error {
-	sourceFile, downloadErr := os.Open(urlP.RequestURI())
-	if downloadErr != nil {
-		klog.Error(downloadErr, " - urlP.RequestURI: ", urlP.RequestURI())
-		return downloadErr
+//fetchFile copies the file located at the url into dest
+func fetchFile(ctx context.Context, parentNamespace string, configMap *corev1.ConfigMap,
+	fileURL string,
+	secret *corev1.Secret,
+	dest string) error {
+	body, err := openFile(ctx, parentNamespace, configMap, fileURL, secret)
+	if err != nil {
+		return err
 	}
 
-	defer sourceFile.Close()
+	defer body.Close()
 
-	// Create new file
-	newFile, downloadErr := os.Create(chartZip)
-	if downloadErr != nil {
-		klog.Error(downloadErr, " - chartZip: ", chartZip)
-		return downloadErr
+	out, err := os.Create(dest)
+	if err != nil {
+		klog.Error(err, " - Failed to create: ", dest)
+		return err
 	}
 
-	defer newFile.Close()
+	defer out.Close()
 
-	_, downloadErr = io.Copy(newFile, sourceFile)
-	if downloadErr != nil {
-		klog.Error(downloadErr)
-		return downloadErr
+	_, err = io.Copy(out, body)
+	if err != nil {
+		klog.Error(err, " - Failed to copy ", fileURL, " to ", dest)
+		return err
 	}
 
 	return nil
 }
 
-func downloadFileHTTP(parentNamespace string, configMap *corev1.ConfigMap,
+//openFile opens the file located at the url, reading more than the maximum download size fails
+//with ErrDownloadTooLarge.
+func openFile(ctx context.Context, parentNamespace string, configMap *corev1.ConfigMap,
 	fileURL string,
-	secret *corev1.Secret,
-	chartZip string) error {
-	if _, err := os.Stat(chartZip); os.IsNotExist(err) {
-		httpClient, downloadErr := GetHelmRepoClient(parentNamespace, configMap)
-		if downloadErr != nil {
-			klog.Error(downloadErr, " - Failed to create httpClient")
-			return downloadErr
-		}
+	secret *corev1.Secret) (io.ReadCloser, error) {
+	URLP, err := url.Parse(fileURL)
+	if err != nil {
+		klog.Error(err, " - url:", fileURL)
+		return nil, err
+	}
 
-		var req *http.Request
+	maxSize := maxDownloadSize()
 
-		req, downloadErr = http.NewRequest(http.MethodGet, fileURL, nil)
-		if downloadErr != nil {
-			klog.Error(downloadErr, "- Can not build request: ", "fileURL", fileURL)
-			return downloadErr
-		}
+	var body io.ReadCloser
 
-		if secret != nil && secret.Data != nil {
-			req.SetBasicAuth(string(secret.Data["user"]), GetPassword(secret))
+	switch URLP.Scheme {
+	case "file":
+		body, err = os.Open(URLP.RequestURI())
+		if err != nil {
+			klog.Error(err, " - urlP.RequestURI: ", URLP.RequestURI())
 		}
+	case "http", "https":
+		body, err = openFileHTTP(ctx, parentNamespace, configMap, fileURL, secret, maxSize)
+	default:
+		err = fmt.Errorf("unsupported scheme %s", URLP.Scheme)
+	}
 
-		var resp *http.Response
+	if err != nil {
+		return nil, err
+	}
 
-		resp, downloadErr = httpClient.Do(req)
-		if downloadErr != nil {
-			klog.Error(downloadErr, "- Http request failed: ", "fileURL", fileURL)
-			return downloadErr
-		}
+	return newLimitedReadCloser(body, fileURL, maxSize), nil
+}
 
-		if resp.StatusCode != 200 {
-			downloadErr = fmt.Errorf("return code: %d unable to retrieve chart", resp.StatusCode)
-			klog.Error(downloadErr, " - Unable to retrieve chart")
+//newLimitedReadCloser returns a reader failing with ErrDownloadTooLarge once maxSize bytes are read
+func newLimitedReadCloser(body io.ReadCloser, fileURL string, maxSize int64) io.ReadCloser {
+	return limitedReadCloser{
+		Reader: &sizeLimitReader{
+			r:         body,
+			remaining: maxSize,
+			err:       fmt.Errorf("%w: %s exceeds %d bytes", ErrDownloadTooLarge, fileURL, maxSize),
+		},
+		Closer: body,
+	}
+}
+
+//limitedReadCloser reads through the size limit and closes the underlying reader
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
 
-			return downloadErr
+//maxDownloadSize returns the maximum size of a downloaded file set in the environment or the default one
+func maxDownloadSize() int64 {
+	if maxSize := os.Getenv(appv1alpha1.ChartsMaxDownloadSize); maxSize != "" {
+		q, err := resource.ParseQuantity(maxSize)
+		if err == nil {
+			return q.Value()
 		}
 
-		klog.V(5).Info("Download chart form helmrepo succeeded: ", fileURL)
+		klog.Error(err, " - Invalid ", appv1alpha1.ChartsMaxDownloadSize, ", using the default")
+	}
 
-		defer resp.Body.Close()
+	return defaultMaxDownloadSize
+}
 
-		var out *os.File
+//downloadChartToCache returns the chart archive from the cache if the digest is known,
+//otherwise the archive is downloaded, verified against the digest and added to the cache.
+//The archive must be released with cache.Release.
+func downloadChartToCache(ctx context.Context,
+	cache *ChartCache,
+	parentNamespace string,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	fileURL string,
+	digest string) (string, error) {
+	digest = strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
 
-		out, downloadErr = os.Create(chartZip)
-		if downloadErr != nil {
-			klog.Error(downloadErr, " - Failed to create: ", chartZip)
-			return downloadErr
+	if digest != "" {
+		if chartZip, ok := cache.Get(digest); ok {
+			klog.V(3).Info("Chart ", fileURL, " found in cache: ", chartZip)
+			return chartZip, nil
 		}
+	}
 
-		defer out.Close()
+	fileName, err := fileNameFromURL(fileURL)
+	if err != nil {
+		return "", err
+	}
 
-		// Write the body to file
-		_, downloadErr = io.Copy(out, resp.Body)
-		if downloadErr != nil {
-			klog.Error(downloadErr, " - Failed to copy body:", chartZip)
-			return downloadErr
-		}
+	tmpFile, err := cache.TempFile()
+	if err != nil {
+		return "", err
 	}
 
-	return nil
-}
+	//The temp file is moved into the cache on success
+	defer os.Remove(tmpFile)
 
-//Untar untars the reader into the dst directory
-func Untar(dst string, r io.Reader) error {
-	gzr, err := gzip.NewReader(r)
+	err = fetchFile(ctx, parentNamespace, configMap, fileURL, secret, tmpFile)
 	if err != nil {
-		klog.Error(err)
-		return err
+		return "", err
 	}
 
-	defer gzr.Close()
+	actual, err := fileSHA256(tmpFile)
+	if err != nil {
+		return "", err
+	}
 
-	tr := tar.NewReader(gzr)
+	if digest != "" && digest != actual {
+		return "", &ChartDigestError{URL: fileURL, Expected: digest, Actual: actual}
+	}
 
-	for {
-		header, err := tr.Next()
+	return cache.Add(tmpFile, fileName, actual)
+}
 
-		switch {
-		case err == io.EOF: // if no more files are found return
-			return nil
-		case err != nil: // return any other error
-			klog.Error(err)
-			return err
-		case header == nil: // if the header is nil, just skip it (not sure how this happens)
-			continue
-		}
+//openFileHTTP returns the body of the file, a file announcing more than maxSize bytes is rejected before the download
+func openFileHTTP(ctx context.Context, parentNamespace string, configMap *corev1.ConfigMap,
+	fileURL string,
+	secret *corev1.Secret,
+	maxSize int64) (io.ReadCloser, error) {
+	httpClient, downloadErr := getHTTPClient(fileURL, configMap, secret)
+	if downloadErr != nil {
+		klog.Error(downloadErr, " - Failed to create httpClient")
+		return nil, downloadErr
+	}
 
-		// the target location where the dir/file should be created
-		target := filepath.Join(dst, header.Name)
+	var req *http.Request
 
-		// the following switch could also be done using fi.Mode(), not sure if there
-		// a benefit of using one vs. the other.
-		// fi := header.FileInfo()
+	req, downloadErr = http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if downloadErr != nil {
+		klog.Error(downloadErr, "- Can not build request: ", "fileURL", fileURL)
+		return nil, downloadErr
+	}
 
-		// check the file type
-		switch header.Typeflag {
-		case tar.TypeDir: // if its a dir and it doesn't exist create it
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0755); err != nil {
-					klog.Error(err)
-					return err
-				}
-			}
-		case tar.TypeReg: // if it's a file create it
-			klog.V(3).Info("Untar to target :", target)
-
-			dir := filepath.Dir(target)
-			if _, err := os.Stat(dir); err != nil {
-				if err := os.MkdirAll(dir, 0755); err != nil {
-					klog.Error(err)
-					return err
-				}
-			}
+	auth, downloadErr := GetAuthProvider(secret)
+	if downloadErr != nil {
+		return nil, downloadErr
+	}
 
-			if _, err := os.Stat(target); err == nil {
-				klog.Info(fmt.Sprintf("A previous version exist of %s then delete", target))
-				os.Remove(target)
-			}
+	downloadErr = auth.Authenticate(httpClient, req)
+	if downloadErr != nil {
+		klog.Error(downloadErr, "- Unable to authenticate the request: ", "fileURL", fileURL)
+		return nil, downloadErr
+	}
 
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, os.FileMode(header.Mode))
-			if err != nil {
-				klog.Error(err)
-				return err
-			}
+	var resp *http.Response
 
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
-				klog.Error(err)
-				return err
-			}
+	resp, downloadErr = doWithRetry(httpClient, req)
+	if downloadErr != nil {
+		klog.Error(downloadErr, "- Http request failed: ", "fileURL", fileURL)
+		return nil, downloadErr
+	}
 
-			// manually close here after each file operation; defering would cause each file close
-			// to wait until all operations have completed.
-			f.Close()
-		}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+
+		downloadErr = newStatusError(fileURL, resp.StatusCode)
+		klog.Error(downloadErr, " - Unable to retrieve chart")
+
+		return nil, downloadErr
 	}
+
+	if resp.ContentLength > maxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s announces %d bytes, the maximum is %d", ErrDownloadTooLarge, fileURL, resp.ContentLength, maxSize)
+	}
+
+	klog.V(5).Info("Download chart form helmrepo succeeded: ", fileURL)
+
+	return resp.Body, nil
 }
 
 func KeywordsChecker(labelSelector *metav1.LabelSelector, ks []string) bool {
@@ -471,18 +814,31 @@ func UnmarshalIndex(data []byte) (*repo.IndexFile, error) {
 	return i, nil
 }
 
-func GenerateGitHubIndexFile(configMap *corev1.ConfigMap,
+//GenerateGitHubIndexFile clones or fetches the repo into destDir and generates the index of the charts of the chartsPath
+func GenerateGitHubIndexFile(ctx context.Context,
+	configMap *corev1.ConfigMap,
 	secret *corev1.Secret,
 	destDir string,
 	urls []string,
 	chartsPath string,
-	branch string) (indexFile *repo.IndexFile, hash string, err error) {
-	hash, err = DownloadGitHubRepo(configMap, secret, destDir, urls, branch)
+	revision GitRevision) (indexFile *repo.IndexFile, hash string, err error) {
+	//The working copy can be shared, it is not updated while the index is generated
+	unlock := lockGitWorkingCopy(destDir)
+	defer unlock()
+
+	commitID, err := DownloadGitHubRepo(ctx, configMap, secret, destDir, urls, revision)
 	if err != nil {
 		klog.Error(err, " - Failed to download the repo")
 		return nil, "", err
 	}
 
+	//The hash is the tree hash of the chartsPath, commits outside of the chartsPath don't change it
+	hash, err = gitTreeHash(destDir, commitID, chartsPath)
+	if err != nil {
+		klog.Error(err, " - Failed to get the tree hash of ", chartsPath)
+		return nil, "", err
+	}
+
 	chartsPath = filepath.Join(destDir, chartsPath)
 	klog.V(3).Info("chartsPath: ", chartsPath)
 
@@ -565,85 +921,242 @@ func generateIndexFile(chartsPath string) (*repo.IndexFile, error) {
 	return indexFile, nil
 }
 
-//GetHelmRepoIndex retrieves the index.yaml, loads it into a repo.IndexFile and filters it
-func GetHelmRepoIndex(configMap *corev1.ConfigMap,
+//GetHelmRepoIndex retrieves the index.yaml, loads it into a repo.IndexFile and filters it.
+//In failover mode the index.yaml of the first url which answers is used, in merge mode the
+//index.yaml of all urls are merged and the first url takes precedence for a same chart version.
+//The chartSources map the ChartSourceKey of each chart version to the url which served it.
+//The requests are aborted when the context is cancelled.
+func GetHelmRepoIndex(ctx context.Context,
+	configMap *corev1.ConfigMap,
 	secret *corev1.Secret,
 	parentNamespace string,
-	urls []string) (indexFile *repo.IndexFile, hash string, err error) {
-	httpClient, err := GetHelmRepoClient(parentNamespace, configMap)
-	if err != nil {
-		klog.Error(err, " - Unable to create client for helm repo",
-			"urls", urls)
-	}
+	urls []string,
+	mode appv1alpha1.HelmRepoMode) (indexFile *repo.IndexFile, hash string, chartSources map[string]string, err error) {
+	return GetHelmRepoPackageIndex(ctx, configMap, secret, parentNamespace, urls, mode, "")
+}
 
-	for _, url := range urls {
-		cleanRepoURL := strings.TrimSuffix(url, "/")
+//GetHelmRepoPackageIndex retrieves the index.yaml like GetHelmRepoIndex but keeps only the entries of
+//the packageName, the other entries are dropped while the index.yaml is read. All entries are kept
+//if the packageName is empty. The hash is computed on the whole index.yaml.
+func GetHelmRepoPackageIndex(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	parentNamespace string,
+	urls []string,
+	mode appv1alpha1.HelmRepoMode,
+	packageName string) (indexFile *repo.IndexFile, hash string, chartSources map[string]string, err error) {
+	if len(urls) == 0 {
+		return nil, "", nil, fmt.Errorf("no helm repo url provided")
+	}
 
-		var req *http.Request
+	switch strings.ToLower(string(mode)) {
+	case "", string(appv1alpha1.HelmRepoModeFailover):
+		for _, repoURL := range urls {
+			indexFile, hash, err = getHelmRepoIndex(ctx, configMap, secret, repoURL, packageName)
+			if err != nil {
+				continue
+			}
 
-		req, err = http.NewRequest(http.MethodGet, cleanRepoURL+"/index.yaml", nil)
-		if err != nil {
-			klog.Error(err, " - Can not build request: ", cleanRepoURL)
-			continue
-		}
+			chartSources = make(map[string]string)
 
-		if secret != nil && secret.Data != nil {
-			if authHeader, ok := secret.Data["authHeader"]; ok {
-				req.Header.Set("Authorization", string(authHeader))
-			} else if user, ok := secret.Data["user"]; ok {
-				if password := GetPassword(secret); password != "" {
-					req.SetBasicAuth(string(user), password)
-				} else {
-					err = fmt.Errorf("password found in secret for basic authentication")
-					continue
+			for _, chartVersions := range indexFile.Entries {
+				for _, chartVersion := range chartVersions {
+					chartSources[ChartSourceKey(chartVersion)] = repoURL
 				}
 			}
+
+			break
 		}
+	case string(appv1alpha1.HelmRepoModeMerge):
+		indexFile = repo.NewIndexFile()
+		chartSources = make(map[string]string)
+		hashes := ""
 
-		var resp *http.Response
+		for _, repoURL := range urls {
+			var repoIndexFile *repo.IndexFile
 
-		resp, err = httpClient.Do(req)
-		if err != nil {
-			klog.Error(err, " - Http request failed: ", "cleanRepoURL", cleanRepoURL)
-			continue
-		}
+			var repoHash string
 
-		if resp.StatusCode != 200 {
-			err = fmt.Errorf("%s %s", resp.Status, cleanRepoURL+"/index.yaml")
-			continue
+			repoIndexFile, repoHash, err = getHelmRepoIndex(ctx, configMap, secret, repoURL, packageName)
+			if err != nil {
+				//A partial index would remove the charts of the failing url
+				klog.Error(err, " - Unable to merge the index.yaml of ", repoURL)
+				return nil, "", nil, err
+			}
+
+			hashes += repoHash
+
+			mergeIndexFile(indexFile, repoIndexFile, repoURL, chartSources)
 		}
 
-		klog.V(5).Info("Get index.yaml succeeded from ", cleanRepoURL)
+		indexFile.SortEntries()
 
-		defer resp.Body.Close()
+		hash, err = HashKey([]byte(hashes))
+	default:
+		err = fmt.Errorf("helm repo mode '%s' unsupported", mode)
+	}
 
-		var body []byte
+	if err != nil {
+		klog.Error(err, " - All repo URL tested and all failed")
+		return nil, "", nil, err
+	}
 
-		body, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			klog.Error(err, " - Unable to read body of ", cleanRepoURL)
-			continue
+	return indexFile, hash, chartSources, err
+}
+
+//ChartSourceKey returns the key of the chart version in the chartSources returned by GetHelmRepoIndex
+func ChartSourceKey(chartVersion *repo.ChartVersion) string {
+	return chartVersion.GetName() + "-" + chartVersion.GetVersion()
+}
+
+//mergeIndexFile adds the chart versions of src not yet in dst
+func mergeIndexFile(dst *repo.IndexFile, src *repo.IndexFile, repoURL string, chartSources map[string]string) {
+	for name, chartVersions := range src.Entries {
+		for _, chartVersion := range chartVersions {
+			key := ChartSourceKey(chartVersion)
+			if source, ok := chartSources[key]; ok {
+				klog.V(5).Info(key, " from ", repoURL, " ignored, already served by ", source)
+				continue
+			}
+
+			chartSources[key] = repoURL
+			dst.Entries[name] = append(dst.Entries[name], chartVersion)
 		}
+	}
+}
 
-		hash, err = HashKey(body)
-		if err != nil {
-			klog.Error(err, " - Unable to generate hashkey")
-			continue
+//getHelmRepoIndex retrieves the index.yaml of a helm repo, the chart urls are resolved against the repo url.
+//The indexes are cached per url and packageName as they only hold the entries of the packageName.
+func getHelmRepoIndex(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	repoURL string,
+	packageName string) (indexFile *repo.IndexFile, hash string, err error) {
+	cleanRepoURL := strings.TrimSuffix(repoURL, "/")
+
+	httpClient, err := getHTTPClient(cleanRepoURL, configMap, secret)
+	if err != nil {
+		klog.Error(err, " - Unable to create client for helm repo ", cleanRepoURL)
+		return nil, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cleanRepoURL+"/index.yaml", nil)
+	if err != nil {
+		klog.Error(err, " - Can not build request: ", cleanRepoURL)
+		return nil, "", err
+	}
+
+	auth, err := GetAuthProvider(secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = auth.Authenticate(httpClient, req)
+	if err != nil {
+		klog.Error(err, " - Unable to authenticate the request: ", cleanRepoURL)
+		return nil, "", err
+	}
+
+	cacheKey := req.URL.String() + "#" + packageName
+
+	cached := indexCache.get(cacheKey)
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
 		}
 
-		indexFile, err = UnmarshalIndex(body)
-		if err != nil {
-			klog.Error(err, " - Unable to parse the indexfile of ", cleanRepoURL)
-			continue
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
 		}
 	}
 
+	resp, err := doWithRetry(httpClient, req)
 	if err != nil {
-		klog.Error(err, " - All repo URL tested and all failed")
+		klog.Error(err, " - Http request failed: ", "cleanRepoURL", cleanRepoURL)
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		klog.V(5).Info("index.yaml not modified at ", cleanRepoURL)
+		return copyIndexFile(cached.indexFile), cached.hash, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, "", newStatusError(cleanRepoURL+"/index.yaml", resp.StatusCode)
+	}
+
+	klog.V(5).Info("Get index.yaml succeeded from ", cleanRepoURL)
+
+	maxSize := maxIndexSize()
+	if resp.ContentLength > maxSize {
+		return nil, "", fmt.Errorf("%w: %s/index.yaml announces %d bytes, the maximum is %d",
+			ErrIndexTooLarge, cleanRepoURL, resp.ContentLength, maxSize)
+	}
+
+	h := sha1.New()
+	body := &bytes.Buffer{}
+
+	//The hash covers the whole index.yaml, only the entries of the package are kept in memory
+	err = filterIndexEntries(io.TeeReader(&sizeLimitReader{
+		r:         resp.Body,
+		remaining: maxSize,
+		err:       fmt.Errorf("%w: %s/index.yaml exceeds %d bytes", ErrIndexTooLarge, cleanRepoURL, maxSize),
+	}, h), body, packageName)
+	if err != nil {
+		klog.Error(err, " - Unable to read body of ", cleanRepoURL)
 		return nil, "", err
 	}
 
-	return indexFile, hash, err
+	hash = string(h.Sum(nil))
+
+	indexFile, err = UnmarshalIndex(body.Bytes())
+	if err != nil {
+		klog.Error(err, " - Unable to parse the indexfile of ", cleanRepoURL)
+		return nil, "", err
+	}
+
+	for _, chartVersions := range indexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			for i := range chartVersion.URLs {
+				chartVersion.URLs[i] = resolveChartURL(cleanRepoURL, chartVersion.URLs[i])
+			}
+		}
+	}
+
+	indexCache.set(cacheKey, &cachedIndex{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		hash:         hash,
+		indexFile:    copyIndexFile(indexFile),
+	})
+
+	return indexFile, hash, nil
+}
+
+//resolveChartURL resolves the local:// and relative chart urls against the repo url
+func resolveChartURL(repoURL string, chartURL string) string {
+	parsedURL, err := url.Parse(chartURL)
+	if err != nil {
+		return chartURL
+	}
+
+	if parsedURL.Scheme == "local" {
+		//make sure there is one and only one slash
+		return strings.Replace(chartURL, "local://", strings.TrimSuffix(repoURL, "/")+"/", 1)
+	}
+
+	if parsedURL.IsAbs() {
+		return chartURL
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(repoURL, "/") + "/")
+	if err != nil {
+		return chartURL
+	}
+
+	return baseURL.ResolveReference(parsedURL).String()
 }
 
 //HashKey Calculate a hash key
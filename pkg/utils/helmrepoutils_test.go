@@ -18,7 +18,10 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -130,7 +133,7 @@ func TestGetConfig(t *testing.T) {
 		Namespace: configMapNS,
 	}
 
-	configMapResp, err := GetConfigMap(c, configMapNS, configMapRef)
+	configMapResp, err := GetConfigMap(context.TODO(), c, configMapNS, configMapRef)
 	assert.NoError(t, err)
 
 	assert.Nil(t, configMapResp)
@@ -151,7 +154,7 @@ func TestGetConfig(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	configMapResp, err = GetConfigMap(c, configMapNS, configMapRef)
+	configMapResp, err = GetConfigMap(context.TODO(), c, configMapNS, configMapRef)
 	assert.NoError(t, err)
 
 	assert.NotNil(t, configMapResp)
@@ -180,7 +183,7 @@ func TestSecret(t *testing.T) {
 		Namespace: secretNS,
 	}
 
-	secretResp, err := GetSecret(c, secretNS, secretRef)
+	secretResp, err := GetSecret(context.TODO(), c, secretNS, secretRef)
 	assert.Error(t, err)
 
 	assert.Nil(t, secretResp)
@@ -201,7 +204,7 @@ func TestSecret(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	secretResp, err = GetSecret(c, secretNS, secretRef)
+	secretResp, err = GetSecret(context.TODO(), c, secretNS, secretRef)
 	assert.NoError(t, err)
 
 	assert.NotNil(t, secretResp)
@@ -231,7 +234,7 @@ func TestDownloadChartGitHub(t *testing.T) {
 
 	defer os.RemoveAll(dir)
 
-	destDir, err := DownloadChart(nil, nil, dir, hr)
+	destDir, err := DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
 	assert.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(destDir, "Chart.yaml"))
@@ -260,7 +263,7 @@ func TestDownloadChartHelmRepo(t *testing.T) {
 
 	defer os.RemoveAll(dir)
 
-	destDir, err := DownloadChart(nil, nil, dir, hr)
+	destDir, err := DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
 	assert.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(destDir, "Chart.yaml"))
@@ -290,7 +293,7 @@ func TestDownloadChartFromGitHub(t *testing.T) {
 
 	defer os.RemoveAll(dir)
 
-	destDir, err := DownloadChartFromGitHub(nil, nil, dir, hr)
+	destDir, err := DownloadChartFromGitHub(context.TODO(), nil, nil, dir, hr)
 	assert.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(destDir, "Chart.yaml"))
@@ -319,7 +322,7 @@ func TestDownloadChartFromHelmRepoHTTP(t *testing.T) {
 
 	defer os.RemoveAll(dir)
 
-	chartDir, err := DownloadChartFromHelmRepo(nil, nil, dir, hr)
+	chartDir, err := DownloadChartFromHelmRepo(context.TODO(), nil, nil, dir, hr)
 	assert.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
@@ -348,13 +351,123 @@ func TestDownloadChartFromHelmRepoLocal(t *testing.T) {
 
 	defer os.RemoveAll(dir)
 
-	chartDir, err := DownloadChartFromHelmRepo(nil, nil, dir, hr)
+	chartDir, err := DownloadChartFromHelmRepo(context.TODO(), nil, nil, dir, hr)
 	assert.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
 	assert.NoError(t, err)
 }
 
+func TestDownloadChartFromHelmRepoDigest(t *testing.T) {
+	hr := &appv1alpha1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "subscription-release-test-1-cr",
+			Namespace: "default",
+		},
+		Spec: appv1alpha1.HelmReleaseSpec{
+			Source: &appv1alpha1.Source{
+				SourceType: appv1alpha1.HelmRepoSourceType,
+				HelmRepo: &appv1alpha1.HelmRepo{
+					Urls: []string{"file:../../test/helmrepo/subscription-release-test-1-0.1.0.tgz"},
+				},
+			},
+			ChartName:   "subscription-release-test-1",
+			ReleaseName: "subscription-release-test-1",
+			Digest:      "2b9ada622755a18b6b9ab72e942f819bf7c2ba7362f15d8e8bf8056429f38769",
+		},
+	}
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	chartDir, err := DownloadChartFromHelmRepo(context.TODO(), nil, nil, dir, hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+	assert.NoError(t, err)
+
+	hr.Spec.Digest = "sha256:1803da017d23edbd1a6cc01e5d63d8f00ca33de49eb2758a2b5e0d6153c009a8"
+
+	_, err = DownloadChartFromHelmRepo(context.TODO(), nil, nil, dir, hr)
+	assert.Error(t, err)
+
+	_, ok := err.(*ChartDigestError)
+	assert.True(t, ok)
+
+	_, err = os.Stat(filepath.Join(dir, "subscription-release-test-1-0.1.0.tgz"))
+	assert.True(t, os.IsNotExist(err))
+
+	//The previous chart is kept and the temporary directory removed
+	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+	assert.NoError(t, err)
+
+	leftovers, err := filepath.Glob(filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+"-stream*"))
+	assert.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestDownloadChartFromHelmRepoStream(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("../../test/helmrepo")))
+	defer server.Close()
+
+	hr := &appv1alpha1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "subscription-release-test-1-cr",
+			Namespace: "default",
+		},
+		Spec: appv1alpha1.HelmReleaseSpec{
+			Source: &appv1alpha1.Source{
+				SourceType: appv1alpha1.HelmRepoSourceType,
+				HelmRepo: &appv1alpha1.HelmRepo{
+					Urls: []string{server.URL + "/subscription-release-test-1-0.1.0.tgz"},
+				},
+			},
+			ChartName:   "subscription-release-test-1",
+			ReleaseName: "subscription-release-test-1",
+		},
+	}
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	//Without digest the archive is extracted without being written to disk
+	chartDir, err := DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, ChartCacheDirName))
+	assert.True(t, os.IsNotExist(err))
+
+	hr.Spec.Digest = "2b9ada622755a18b6b9ab72e942f819bf7c2ba7362f15d8e8bf8056429f38769"
+
+	_, err = DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, ChartCacheDirName, hr.Spec.Digest, "subscription-release-test-1-0.1.0.tgz"))
+	assert.NoError(t, err)
+
+	os.Setenv(appv1alpha1.ChartsMaxDownloadSize, "1Ki")
+	defer os.Unsetenv(appv1alpha1.ChartsMaxDownloadSize)
+
+	//The announced size is checked before the download, the local file is cut while being read
+	hr.Spec.Digest = ""
+
+	for _, url := range []string{server.URL + "/subscription-release-test-1-0.1.0.tgz",
+		"file:../../test/helmrepo/subscription-release-test-1-0.1.0.tgz"} {
+		hr.Spec.Source.HelmRepo.Urls = []string{url}
+
+		_, err = DownloadChart(context.TODO(), nil, nil, nil, dir, hr)
+		assert.True(t, errors.Is(err, ErrDownloadTooLarge), url)
+
+		_, err = os.Stat(chartDir)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
 func TestDownloadGitHubRepo(t *testing.T) {
 	dir, err := ioutil.TempDir("/tmp", "charts")
 	assert.NoError(t, err)
@@ -362,8 +475,8 @@ func TestDownloadGitHubRepo(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	destRepo := filepath.Join(dir, "test")
-	commitID, err := DownloadGitHubRepo(nil, nil, destRepo,
-		[]string{"https://github.com/IBM/multicloud-operators-subscription-release.git"}, "")
+	commitID, err := DownloadGitHubRepo(context.TODO(), nil, nil, destRepo,
+		[]string{"https://github.com/IBM/multicloud-operators-subscription-release.git"}, GitRevision{})
 	assert.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(destRepo, "OWNERS"))
@@ -418,13 +531,127 @@ func TestUnmarshalIndex(t *testing.T) {
 	assert.Equal(t, "ibm-cfee-installer", name)
 }
 func TestGetHelmIndex(t *testing.T) {
-	indexFile, hash, err := GetHelmRepoIndex(nil, nil, "",
-		[]string{"https://raw.github.com/IBM/multicloud-operators-subscription-release/master/test/helmrepo"})
+	indexFile, hash, _, err := GetHelmRepoIndex(context.TODO(), nil, nil, "",
+		[]string{"https://raw.github.com/IBM/multicloud-operators-subscription-release/master/test/helmrepo"}, "")
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, "", hash)
+
+	assert.Equal(t, 2, len(indexFile.Entries))
+}
+
+const mirrorIndex = `apiVersion: v1
+entries:
+  subscription-release-test-1:
+  - apiVersion: v1
+    name: subscription-release-test-1
+    urls:
+    - charts/subscription-release-test-1-0.2.0.tgz
+    version: 0.2.0
+`
+
+const mergedIndex = `apiVersion: v1
+entries:
+  subscription-release-test-1:
+  - apiVersion: v1
+    name: subscription-release-test-1
+    urls:
+    - local://subscription-release-test-1-0.2.0.tgz
+    version: 0.2.0
+  - apiVersion: v1
+    name: subscription-release-test-1
+    urls:
+    - local://subscription-release-test-1-0.1.0.tgz
+    version: 0.1.0
+  subscription-release-test-2:
+  - apiVersion: v1
+    name: subscription-release-test-2
+    urls:
+    - https://charts.example.com/subscription-release-test-2-0.1.0.tgz
+    version: 0.1.0
+`
+
+func newIndexServer(index string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(index))
+	}))
+}
+
+func TestGetHelmIndexNotModified(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(mergedIndex))
+	}))
+
+	defer server.Close()
+
+	indexFile, hash, _, err := GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{server.URL}, "")
 	assert.NoError(t, err)
+	assert.Equal(t, 2, len(indexFile.Entries))
+
+	//The callers filter the returned index, it must not change the cached one
+	delete(indexFile.Entries, "subscription-release-test-2")
+	indexFile.Entries["subscription-release-test-1"][0].URLs[0] = "modified"
+
+	indexFile, notModifiedHash, _, err := GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{server.URL}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, downloads)
+	assert.Equal(t, hash, notModifiedHash)
+	assert.Equal(t, 2, len(indexFile.Entries))
+	assert.Equal(t, server.URL+"/subscription-release-test-1-0.2.0.tgz", indexFile.Entries["subscription-release-test-1"][0].URLs[0])
+}
+
+func TestGetHelmIndexModes(t *testing.T) {
+	mirror := newIndexServer(mirrorIndex)
+	defer mirror.Close()
+
+	merged := newIndexServer(mergedIndex)
+	defer merged.Close()
+
+	urls := []string{mirror.URL + "/", merged.URL}
 
+	//failover stops at the first url which answers
+	indexFile, hash, chartSources, err := GetHelmRepoIndex(context.TODO(), nil, nil, "", urls, appv1alpha1.HelmRepoModeFailover)
+	assert.NoError(t, err)
 	assert.NotEqual(t, "", hash)
+	assert.Equal(t, 1, len(indexFile.Entries))
+	assert.Equal(t, mirror.URL+"/charts/subscription-release-test-1-0.2.0.tgz", indexFile.Entries["subscription-release-test-1"][0].URLs[0])
+	assert.Equal(t, mirror.URL+"/", chartSources["subscription-release-test-1-0.2.0"])
 
+	indexFile, _, chartSources, err = GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{"http://127.0.0.1:1", merged.URL}, "")
+	assert.NoError(t, err)
 	assert.Equal(t, 2, len(indexFile.Entries))
+	assert.Equal(t, merged.URL+"/subscription-release-test-1-0.1.0.tgz", indexFile.Entries["subscription-release-test-1"][1].URLs[0])
+	assert.Equal(t, merged.URL, chartSources["subscription-release-test-1-0.1.0"])
+
+	//merge takes the first url for a same chart version
+	indexFile, _, chartSources, err = GetHelmRepoIndex(context.TODO(), nil, nil, "", urls, appv1alpha1.HelmRepoModeMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(indexFile.Entries))
+
+	chartVersions := indexFile.Entries["subscription-release-test-1"]
+	assert.Equal(t, 2, len(chartVersions))
+	assert.Equal(t, mirror.URL+"/charts/subscription-release-test-1-0.2.0.tgz", chartVersions[0].URLs[0])
+	assert.Equal(t, mirror.URL+"/", chartSources["subscription-release-test-1-0.2.0"])
+	assert.Equal(t, merged.URL, chartSources["subscription-release-test-1-0.1.0"])
+	assert.Equal(t, "https://charts.example.com/subscription-release-test-2-0.1.0.tgz",
+		indexFile.Entries["subscription-release-test-2"][0].URLs[0])
+
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{"http://127.0.0.1:1", merged.URL}, appv1alpha1.HelmRepoModeMerge)
+	assert.Error(t, err)
+
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), nil, nil, "", urls, "unknown")
+	assert.Error(t, err)
 }
 
 func TestGenerateHelmIndexYAML(t *testing.T) {
@@ -434,10 +661,10 @@ func TestGenerateHelmIndexYAML(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	destRepo := filepath.Join(dir, "test")
-	indexFile, hash, err := GenerateGitHubIndexFile(nil, nil,
+	indexFile, hash, err := GenerateGitHubIndexFile(context.TODO(), nil, nil,
 		destRepo,
 		[]string{"https://github.com/IBM/multicloud-operators-subscription-release.git"},
-		"test/github", "")
+		"test/github", GitRevision{})
 	assert.NoError(t, err)
 
 	assert.NotEqual(t, "", hash)
@@ -0,0 +1,257 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	//TimeoutConfigMapKey key in the configMap holding the timeout of a whole request, none by default
+	TimeoutConfigMapKey = "timeout"
+	//ConnectTimeoutConfigMapKey key in the configMap holding the timeout to establish a connection
+	ConnectTimeoutConfigMapKey = "connectTimeout"
+	//TLSHandshakeTimeoutConfigMapKey key in the configMap holding the timeout of the TLS handshake
+	TLSHandshakeTimeoutConfigMapKey = "tlsHandshakeTimeout"
+	//ResponseHeaderTimeoutConfigMapKey key in the configMap holding the timeout to receive the response headers, none by default
+	ResponseHeaderTimeoutConfigMapKey = "responseHeaderTimeout"
+)
+
+const (
+	defaultConnectTimeout      = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	//httpClientMaxIdle is the time after which an unused client is removed from the pool
+	httpClientMaxIdle = time.Hour
+	//httpClientMaxClients bounds the pool, the least recently used client is removed first
+	httpClientMaxClients = 64
+)
+
+//httpClientSettings are the settings of the configMap and of the secret a client is built from
+var httpClientSettings = []struct {
+	configMapKey string
+	secretKey    string
+}{
+	{configMapKey: InsecureSkipVerifyConfigMapKey},
+	{configMapKey: CABundleConfigMapKey},
+	{configMapKey: TimeoutConfigMapKey},
+	{configMapKey: ConnectTimeoutConfigMapKey},
+	{configMapKey: TLSHandshakeTimeoutConfigMapKey},
+	{configMapKey: ResponseHeaderTimeoutConfigMapKey},
+	{configMapKey: HTTPProxyConfigMapKey},
+	{configMapKey: HTTPSProxyConfigMapKey},
+	{configMapKey: NoProxyConfigMapKey},
+	{secretKey: ClientCertSecretKey},
+	{secretKey: ClientKeySecretKey},
+	{secretKey: ProxyUserSecretKey},
+	{secretKey: ProxyPasswordSecretKey},
+}
+
+//hasHTTPClientSettings returns true if the configMap or the secret customize the http client
+func hasHTTPClientSettings(configMap *corev1.ConfigMap, secret *corev1.Secret) bool {
+	for _, setting := range httpClientSettings {
+		if configMap != nil && setting.configMapKey != "" {
+			if _, ok := configMap.Data[setting.configMapKey]; ok {
+				return true
+			}
+		}
+
+		if secret != nil && setting.secretKey != "" {
+			if _, ok := secret.Data[setting.secretKey]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type pooledHTTPClient struct {
+	client   *http.Client
+	lastUsed time.Time
+}
+
+//httpClientPool keeps an http client per repository host and settings, the connections of a repository
+//are reused across the polls. A client is never modified once created so the settings of a repository
+//don't leak to the others.
+type httpClientPool struct {
+	mutex   sync.Mutex
+	clients map[string]*pooledHTTPClient
+}
+
+var httpClients = &httpClientPool{clients: make(map[string]*pooledHTTPClient)}
+
+//getHTTPClient returns the pooled client of the repository for the settings of the configMap and the secret
+func getHTTPClient(repoURL string, configMap *corev1.ConfigMap, secret *corev1.Secret) (*http.Client, error) {
+	return httpClients.get(repositoryHost(repoURL), configMap, secret)
+}
+
+//repositoryHost returns the host and port of the url in lower case, the url itself if it can't be parsed
+func repositoryHost(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return repoURL
+	}
+
+	return strings.ToLower(u.Host)
+}
+
+//get returns the client for the host and the settings, it is created on first use
+func (p *httpClientPool) get(host string, configMap *corev1.ConfigMap, secret *corev1.Secret) (*http.Client, error) {
+	key := host + "#" + httpClientKey(configMap, secret)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+
+	for k, c := range p.clients {
+		if k != key && now.Sub(c.lastUsed) > httpClientMaxIdle {
+			p.remove(k)
+		}
+	}
+
+	if c, ok := p.clients[key]; ok {
+		c.lastUsed = now
+		return c.client, nil
+	}
+
+	client, err := newHTTPClient(configMap, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(p.clients) >= httpClientMaxClients {
+		oldestKey := ""
+
+		var oldest time.Time
+
+		for k, c := range p.clients {
+			if oldestKey == "" || c.lastUsed.Before(oldest) {
+				oldestKey = k
+				oldest = c.lastUsed
+			}
+		}
+
+		p.remove(oldestKey)
+	}
+
+	klog.V(5).Info("Add http client ", key)
+	p.clients[key] = &pooledHTTPClient{client: client, lastUsed: now}
+
+	return client, nil
+}
+
+func (p *httpClientPool) remove(key string) {
+	klog.V(5).Info("Remove unused http client ", key)
+	p.clients[key].client.CloseIdleConnections()
+	delete(p.clients, key)
+}
+
+//httpClientKey hashes the settings, the secret material is not kept in the pool
+func httpClientKey(configMap *corev1.ConfigMap, secret *corev1.Secret) string {
+	h := sha256.New()
+
+	for _, setting := range httpClientSettings {
+		var value []byte
+
+		switch {
+		case setting.configMapKey != "" && configMap != nil:
+			value = []byte(configMap.Data[setting.configMapKey])
+		case setting.secretKey != "" && secret != nil:
+			value = secret.Data[setting.secretKey]
+		}
+
+		fmt.Fprintf(h, "%d:", len(value))
+		h.Write(value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//newHTTPClient builds a client with its own transport out of the TLS, timeouts and proxy settings
+func newHTTPClient(configMap *corev1.ConfigMap, secret *corev1.Secret) (*http.Client, error) {
+	tlsConfig, err := getTLSConfig(configMap, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := getProxy(configMap, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts := map[string]time.Duration{
+		TimeoutConfigMapKey:               0,
+		ConnectTimeoutConfigMapKey:        defaultConnectTimeout,
+		TLSHandshakeTimeoutConfigMapKey:   defaultTLSHandshakeTimeout,
+		ResponseHeaderTimeoutConfigMapKey: 0,
+	}
+
+	if configMap != nil {
+		for key := range timeouts {
+			value := configMap.Data[key]
+			if value == "" {
+				continue
+			}
+
+			d, err := time.ParseDuration(value)
+			if err == nil && d < 0 {
+				err = fmt.Errorf("negative duration")
+			}
+
+			if err != nil {
+				klog.Error(err, " - Unable to parse ", key, ": ", value)
+				return nil, fmt.Errorf("%s of configMap %s must be a positive duration: %v", key, configMap.Name, err)
+			}
+
+			timeouts[key] = d
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   timeouts[ConnectTimeoutConfigMapKey],
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeouts[TLSHandshakeTimeoutConfigMapKey],
+		ResponseHeaderTimeout: timeouts[ResponseHeaderTimeoutConfigMapKey],
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	klog.V(5).Info("InsecureSkipVerify equal ", transport.TLSClientConfig.InsecureSkipVerify)
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeouts[TimeoutConfigMapKey],
+	}, nil
+}
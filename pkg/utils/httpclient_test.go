@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestHTTPClientPool(t *testing.T) {
+	pool := &httpClientPool{clients: make(map[string]*pooledHTTPClient)}
+
+	insecure := &corev1.ConfigMap{Data: map[string]string{InsecureSkipVerifyConfigMapKey: "true"}}
+
+	secure, err := pool.get("repo.example.com", nil, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, http.DefaultClient, secure)
+
+	insecureClient, err := pool.get("repo.example.com", insecure, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, secure, insecureClient)
+
+	//The settings of a repository don't leak to the others
+	assert.False(t, secure.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	assert.True(t, insecureClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+
+	//A same client is reused for the same settings
+	client, err := pool.get("repo.example.com", &corev1.ConfigMap{Data: map[string]string{InsecureSkipVerifyConfigMapKey: "true"}}, nil)
+	assert.NoError(t, err)
+	assert.True(t, client == insecureClient)
+
+	client, err = pool.get("repo.example.com", nil, &corev1.Secret{Data: map[string][]byte{"user": []byte("user")}})
+	assert.NoError(t, err)
+	assert.True(t, client == secure)
+
+	assert.False(t, hasHTTPClientSettings(nil, &corev1.Secret{Data: map[string][]byte{"user": []byte("user")}}))
+	assert.True(t, hasHTTPClientSettings(insecure, nil))
+	assert.True(t, hasHTTPClientSettings(nil, &corev1.Secret{Data: map[string][]byte{ProxyUserSecretKey: []byte("user")}}))
+
+	//An unused client is removed
+	pool.clients["repo.example.com#"+httpClientKey(insecure, nil)].lastUsed = time.Now().Add(-2 * httpClientMaxIdle)
+
+	_, err = pool.get("repo.example.com", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pool.clients))
+
+	//Each repository has its own client
+	client, err = pool.get("other.example.com", nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, client == secure)
+	assert.Equal(t, "other.example.com:8443", repositoryHost("https://Other.example.com:8443/charts"))
+
+	//The least recently used client is removed once the pool is full
+	pool.clients["repo.example.com#"+httpClientKey(nil, nil)].lastUsed = time.Now().Add(-time.Minute)
+
+	for i := len(pool.clients); i < httpClientMaxClients+1; i++ {
+		_, err = pool.get(fmt.Sprintf("repo%d.example.com", i), nil, nil)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, httpClientMaxClients, len(pool.clients))
+	assert.Nil(t, pool.clients["repo.example.com#"+httpClientKey(nil, nil)])
+	assert.NotNil(t, pool.clients["other.example.com#"+httpClientKey(nil, nil)])
+}
+
+func TestHTTPClientTimeouts(t *testing.T) {
+	client, err := newHTTPClient(&corev1.ConfigMap{Data: map[string]string{
+		TimeoutConfigMapKey:               "2m",
+		ConnectTimeoutConfigMapKey:        "5s",
+		ResponseHeaderTimeoutConfigMapKey: "1m",
+	}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, client.Timeout)
+
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, time.Minute, transport.ResponseHeaderTimeout)
+	assert.Equal(t, defaultTLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+
+	_, err = newHTTPClient(&corev1.ConfigMap{Data: map[string]string{TimeoutConfigMapKey: "2"}}, nil)
+	assert.Error(t, err)
+
+	_, err = newHTTPClient(&corev1.ConfigMap{Data: map[string]string{TimeoutConfigMapKey: "-1s"}}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+const (
+	//indexCacheTTL the indexes not used for this duration are dropped, their subscription is likely removed
+	indexCacheTTL = time.Hour
+	//indexCacheMaxChartVersions bounds the chart versions kept by the cache, the least recently used
+	//indexes are dropped first and a larger index is not cached
+	indexCacheMaxChartVersions = 20000
+)
+
+//cachedIndex is the last index.yaml retrieved from an url with its validators
+type cachedIndex struct {
+	etag         string
+	lastModified string
+	hash         string
+	indexFile    *repo.IndexFile
+	//size the number of chart versions of the index
+	size     int
+	lastUsed time.Time
+}
+
+//indexFileCache keeps the last index.yaml per url to send conditional requests
+type indexFileCache struct {
+	mutex   sync.Mutex
+	indexes map[string]*cachedIndex
+	//size the number of chart versions of all the indexes
+	size int
+}
+
+var indexCache = &indexFileCache{indexes: make(map[string]*cachedIndex)}
+
+func (c *indexFileCache) get(url string) *cachedIndex {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	index := c.indexes[url]
+	if index == nil {
+		return nil
+	}
+
+	if time.Since(index.lastUsed) > indexCacheTTL {
+		c.remove(url)
+		return nil
+	}
+
+	index.lastUsed = time.Now()
+
+	return index
+}
+
+//set keeps the index only if the server provided a validator
+func (c *indexFileCache) set(url string, index *cachedIndex) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.remove(url)
+
+	if index.etag == "" && index.lastModified == "" {
+		return
+	}
+
+	index.size = 0
+	for _, chartVersions := range index.indexFile.Entries {
+		index.size += len(chartVersions)
+	}
+
+	if index.size > indexCacheMaxChartVersions {
+		return
+	}
+
+	index.lastUsed = time.Now()
+	c.indexes[url] = index
+	c.size += index.size
+
+	c.evict()
+}
+
+func (c *indexFileCache) remove(url string) {
+	if index, ok := c.indexes[url]; ok {
+		c.size -= index.size
+		delete(c.indexes, url)
+	}
+}
+
+//evict drops the expired indexes then the least recently used ones until the cache fits its bound
+func (c *indexFileCache) evict() {
+	for url, index := range c.indexes {
+		if time.Since(index.lastUsed) > indexCacheTTL {
+			c.remove(url)
+		}
+	}
+
+	for c.size > indexCacheMaxChartVersions {
+		oldestURL := ""
+
+		var oldest time.Time
+
+		for url, index := range c.indexes {
+			if oldestURL == "" || index.lastUsed.Before(oldest) {
+				oldestURL = url
+				oldest = index.lastUsed
+			}
+		}
+
+		c.remove(oldestURL)
+	}
+}
+
+//copyIndexFile copies the entries and the chart versions, the callers filter and modify them
+func copyIndexFile(indexFile *repo.IndexFile) *repo.IndexFile {
+	newIndexFile := &repo.IndexFile{
+		APIVersion: indexFile.APIVersion,
+		Generated:  indexFile.Generated,
+		Entries:    make(map[string]repo.ChartVersions, len(indexFile.Entries)),
+		PublicKeys: indexFile.PublicKeys,
+	}
+
+	for name, chartVersions := range indexFile.Entries {
+		newChartVersions := make(repo.ChartVersions, len(chartVersions))
+
+		for i, chartVersion := range chartVersions {
+			newChartVersion := *chartVersion
+			newChartVersion.URLs = append([]string(nil), chartVersion.URLs...)
+			newChartVersions[i] = &newChartVersion
+		}
+
+		newIndexFile.Entries[name] = newChartVersions
+	}
+
+	return newIndexFile
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/helm/pkg/repo"
+)
+
+//newCachedIndex returns an index holding size chart versions of a package
+func newCachedIndex(size int) *cachedIndex {
+	chartVersions := make(repo.ChartVersions, size)
+	for i := range chartVersions {
+		chartVersions[i] = &repo.ChartVersion{}
+	}
+
+	return &cachedIndex{
+		etag:      "etag",
+		indexFile: &repo.IndexFile{Entries: map[string]repo.ChartVersions{"mychart": chartVersions}},
+	}
+}
+
+func TestIndexFileCache(t *testing.T) {
+	c := &indexFileCache{indexes: make(map[string]*cachedIndex)}
+
+	c.set("url1", newCachedIndex(indexCacheMaxChartVersions/2))
+	c.set("url2", newCachedIndex(indexCacheMaxChartVersions/2))
+	assert.Equal(t, indexCacheMaxChartVersions, c.size)
+
+	//url2 is the least recently used once url1 is read
+	c.indexes["url1"].lastUsed = time.Now().Add(-time.Minute)
+	c.indexes["url2"].lastUsed = time.Now().Add(-time.Minute)
+	assert.NotNil(t, c.get("url1"))
+
+	c.set("url3", newCachedIndex(1))
+	assert.NotNil(t, c.get("url1"))
+	assert.Nil(t, c.get("url2"))
+	assert.NotNil(t, c.get("url3"))
+	assert.Equal(t, indexCacheMaxChartVersions/2+1, c.size)
+
+	//An index larger than the bound is not cached
+	c.set("url3", newCachedIndex(indexCacheMaxChartVersions+1))
+	assert.Nil(t, c.get("url3"))
+
+	//An index without validator is not cached
+	c.set("url1", &cachedIndex{indexFile: &repo.IndexFile{}})
+	assert.Nil(t, c.get("url1"))
+	assert.Equal(t, 0, c.size)
+
+	//The indexes not used for the TTL are dropped
+	c.set("url1", newCachedIndex(1))
+	c.indexes["url1"].lastUsed = time.Now().Add(-indexCacheTTL - time.Minute)
+	assert.Nil(t, c.get("url1"))
+	assert.Equal(t, 0, len(c.indexes))
+}
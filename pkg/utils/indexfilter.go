@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+//defaultMaxIndexSize is the maximum size of an index.yaml when CHARTS_MAX_INDEX_SIZE is not set
+const defaultMaxIndexSize int64 = 64 << 20
+
+//ErrIndexTooLarge the index.yaml exceeds the maximum index size
+var ErrIndexTooLarge = errors.New("index.yaml exceeds the maximum size")
+
+//maxIndexSize returns the maximum size of an index.yaml set in the environment or the default one
+func maxIndexSize() int64 {
+	if maxSize := os.Getenv(appv1alpha1.ChartsMaxIndexSize); maxSize != "" {
+		q, err := resource.ParseQuantity(maxSize)
+		if err == nil {
+			return q.Value()
+		}
+
+		klog.Error(err, " - Invalid ", appv1alpha1.ChartsMaxIndexSize, ", using the default")
+	}
+
+	return defaultMaxIndexSize
+}
+
+//filterIndexEntries copies the index.yaml from r to w without the entries of the charts other than
+//the packageName, the entries are dropped line by line so only the kept entries are held in memory.
+//The whole index.yaml is copied if the packageName is empty or if the entries are not in the block
+//style written by helm, r is always read to the end.
+func filterIndexEntries(r io.Reader, w io.Writer, packageName string) error {
+	if packageName == "" {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	filter := &indexEntriesFilter{packageName: packageName, entryIndent: -1}
+
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" && filter.keepLine(line) {
+			if _, errWrite := io.WriteString(w, line); errWrite != nil {
+				return errWrite
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//indexEntriesFilter tracks whether the current line of the index.yaml is in the entries and in the entry of the packageName
+type indexEntriesFilter struct {
+	packageName string
+	inEntries   bool
+	keep        bool
+	passthrough bool
+	entryIndent int
+}
+
+//keepLine returns true if the line of the index.yaml must be kept
+func (f *indexEntriesFilter) keepLine(line string) bool {
+	if f.passthrough {
+		return true
+	}
+
+	content := strings.TrimRight(line, " \t\r\n")
+	trimmed := strings.TrimLeft(content, " ")
+
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return !f.inEntries || f.keep
+	}
+
+	indent := len(content) - len(trimmed)
+
+	if indent == 0 {
+		//A top level key
+		f.inEntries = false
+
+		switch {
+		case trimmed == "entries:":
+			f.inEntries = true
+			f.keep = false
+			f.entryIndent = -1
+		case strings.HasPrefix(trimmed, "entries:") || strings.HasPrefix(trimmed, "{"):
+			klog.V(3).Info("index.yaml entries not in block style, the entries are not filtered")
+
+			f.passthrough = true
+		}
+
+		return true
+	}
+
+	if !f.inEntries {
+		return true
+	}
+
+	if f.entryIndent < 0 {
+		f.entryIndent = indent
+	}
+
+	//The chart versions are listed at the indentation of the chart name or deeper
+	if indent == f.entryIndent && !strings.HasPrefix(trimmed, "-") {
+		f.keep = indexEntryName(trimmed) == f.packageName
+	}
+
+	return f.keep
+}
+
+//indexEntryName returns the chart name of a "<name>:" line of the entries
+func indexEntryName(line string) string {
+	name := line
+	if i := strings.Index(line, ":"); i >= 0 {
+		name = line[:i]
+	}
+
+	return strings.Trim(strings.TrimSpace(name), `"'`)
+}
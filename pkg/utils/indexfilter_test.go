@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+const indentedIndex = `apiVersion: v1
+entries:
+    # the chart versions are indented
+    "ibm-mcm-prod":
+        - name: ibm-mcm-prod
+          version: 3.1.2
+    ibm-mcmk-prod: []
+generated: 2019-06-25T17:38:32.511798404Z
+`
+
+const jsonIndex = `{"apiVersion": "v1", "entries": {"ibm-mcm-prod": [{"name": "ibm-mcm-prod", "version": "3.1.2"}],
+"ibm-mcmk-prod": [{"name": "ibm-mcmk-prod", "version": "3.1.3"}]}}
+`
+
+func filteredIndex(t *testing.T, index string, packageName string) map[string]int {
+	out := &bytes.Buffer{}
+
+	err := filterIndexEntries(strings.NewReader(index), out, packageName)
+	assert.NoError(t, err)
+
+	indexFile, err := UnmarshalIndex(out.Bytes())
+	assert.NoError(t, err)
+
+	versions := make(map[string]int)
+	for name, chartVersions := range indexFile.Entries {
+		versions[name] = len(chartVersions)
+	}
+
+	return versions
+}
+
+func TestFilterIndexEntries(t *testing.T) {
+	assert.Equal(t, map[string]int{"ibm-cfee-installer": 2, "ibm-mcm-prod": 1, "ibm-mcmk-prod": 1}, filteredIndex(t, index, ""))
+	assert.Equal(t, map[string]int{"ibm-cfee-installer": 2}, filteredIndex(t, index, "ibm-cfee-installer"))
+	assert.Equal(t, map[string]int{"ibm-mcm-prod": 1}, filteredIndex(t, index, "ibm-mcm-prod"))
+	assert.Equal(t, map[string]int{}, filteredIndex(t, index, "unknown"))
+
+	assert.Equal(t, map[string]int{"ibm-mcm-prod": 1}, filteredIndex(t, indentedIndex, "ibm-mcm-prod"))
+	assert.Equal(t, map[string]int{"ibm-mcmk-prod": 0}, filteredIndex(t, indentedIndex, "ibm-mcmk-prod"))
+
+	//The entries not in block style are not filtered
+	assert.Equal(t, map[string]int{"ibm-mcm-prod": 1, "ibm-mcmk-prod": 1}, filteredIndex(t, jsonIndex, "ibm-mcm-prod"))
+}
+
+func TestGetHelmRepoPackageIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/chunked") {
+			//The size is not announced
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte(index))
+	}))
+
+	defer server.Close()
+
+	indexFile, hash, chartSources, err := GetHelmRepoPackageIndex(context.TODO(), nil, nil, "", []string{server.URL}, "", "ibm-mcm-prod")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(indexFile.Entries))
+	assert.Equal(t, map[string]string{"ibm-mcm-prod-3.1.2": server.URL}, chartSources)
+
+	//The hash covers the whole index.yaml
+	_, fullHash, _, err := GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{server.URL}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, fullHash, hash)
+
+	os.Setenv(appv1alpha1.ChartsMaxIndexSize, "1Ki")
+	defer os.Unsetenv(appv1alpha1.ChartsMaxIndexSize)
+
+	for _, url := range []string{server.URL, server.URL + "/chunked"} {
+		_, _, _, err = GetHelmRepoPackageIndex(context.TODO(), nil, nil, "", []string{url}, "", "ibm-mcm-prod")
+		assert.True(t, errors.Is(err, ErrIndexTooLarge), url)
+	}
+}
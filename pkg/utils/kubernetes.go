@@ -72,7 +72,7 @@ func GetPassword(secret *corev1.Secret) string {
 }
 
 //GetConfigMap search the config map containing the helm repo client configuration.
-func GetConfigMap(client client.Client, parentNamespace string, configMapRef *corev1.ObjectReference) (configMap *corev1.ConfigMap, err error) {
+func GetConfigMap(ctx context.Context, client client.Client, parentNamespace string, configMapRef *corev1.ObjectReference) (configMap *corev1.ConfigMap, err error) {
 	if configMapRef != nil {
 		klog.V(5).Info("Retrieve configMap ", parentNamespace, "/", configMapRef.Name)
 		ns := configMapRef.Namespace
@@ -83,7 +83,7 @@ func GetConfigMap(client client.Client, parentNamespace string, configMapRef *co
 
 		configMap = &corev1.ConfigMap{}
 
-		err = client.Get(context.TODO(), types.NamespacedName{Namespace: ns, Name: configMapRef.Name}, configMap)
+		err = client.Get(ctx, types.NamespacedName{Namespace: ns, Name: configMapRef.Name}, configMap)
 		if err != nil {
 			if errors.IsNotFound(err) {
 				return nil, nil
@@ -103,7 +103,7 @@ func GetConfigMap(client client.Client, parentNamespace string, configMapRef *co
 }
 
 //GetSecret returns the secret to access the helm-repo
-func GetSecret(client client.Client, parentNamespace string, secretRef *corev1.ObjectReference) (secret *corev1.Secret, err error) {
+func GetSecret(ctx context.Context, client client.Client, parentNamespace string, secretRef *corev1.ObjectReference) (secret *corev1.Secret, err error) {
 	if secretRef != nil {
 		klog.V(5).Info("retrieve secret :", parentNamespace, "/", secretRef)
 
@@ -114,7 +114,7 @@ func GetSecret(client client.Client, parentNamespace string, secretRef *corev1.O
 
 		secret = &corev1.Secret{}
 
-		err = client.Get(context.TODO(), types.NamespacedName{Namespace: ns, Name: secretRef.Name}, secret)
+		err = client.Get(ctx, types.NamespacedName{Namespace: ns, Name: secretRef.Name}, secret)
 		if err != nil {
 			return nil, err
 		}
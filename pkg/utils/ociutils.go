@@ -0,0 +1,616 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/klog"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+const (
+	ociScheme = "oci://"
+	//HelmChartContentLayerMediaType media type of the OCI layer holding the chart archive
+	HelmChartContentLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	//helmChartLegacyLayerMediaType media type used by the first helm OCI experiments
+	helmChartLegacyLayerMediaType = "application/tar+gzip"
+	ociManifestMediaType          = "application/vnd.oci.image.manifest.v1+json"
+	//ociMaxTagPages is the maximum number of pages of tags listed for a repository
+	ociMaxTagPages = 100
+)
+
+var ociChallengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+//OCIReference is a parsed oci://<registry>/<repository>[:<tag>][@<digest>]
+type OCIReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociTagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+//ParseOCIReference parses an oci:// url into an OCIReference
+func ParseOCIReference(ref string) (*OCIReference, error) {
+	if !strings.HasPrefix(ref, ociScheme) {
+		return nil, fmt.Errorf("oci reference %s must start with %s", ref, ociScheme)
+	}
+
+	name := strings.TrimPrefix(ref, ociScheme)
+
+	i := strings.Index(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return nil, fmt.Errorf("oci reference %s has no repository", ref)
+	}
+
+	o := &OCIReference{Registry: name[:i]}
+	name = name[i+1:]
+
+	if at := strings.Index(name, "@"); at >= 0 {
+		o.Digest = name[at+1:]
+		name = name[:at]
+	}
+
+	if c := strings.LastIndex(name, ":"); c > strings.LastIndex(name, "/") {
+		o.Tag = name[c+1:]
+		name = name[:c]
+	}
+
+	o.Repository = strings.TrimSuffix(name, "/")
+
+	return o, nil
+}
+
+//ChartName returns the name of the chart, the last element of the repository
+func (o *OCIReference) ChartName() string {
+	return path.Base(o.Repository)
+}
+
+//Reference returns the digest if set, otherwise the tag
+func (o *OCIReference) Reference() string {
+	if o.Digest != "" {
+		return o.Digest
+	}
+
+	if o.Tag != "" {
+		return o.Tag
+	}
+
+	return "latest"
+}
+
+func (o *OCIReference) String() string {
+	s := ociScheme + o.Registry + "/" + o.Repository
+	if o.Tag != "" {
+		s += ":" + o.Tag
+	}
+
+	if o.Digest != "" {
+		s += "@" + o.Digest
+	}
+
+	return s
+}
+
+func (o *OCIReference) apiURL(kind, ref string) string {
+	return fmt.Sprintf("https://%s/v2/%s/%s/%s", o.Registry, o.Repository, kind, ref)
+}
+
+//DownloadChartFromOCI downloads a chart from an OCI registry into the destRepo
+func DownloadChartFromOCI(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+	chartsDir, err := GetChartsDir()
+	if err != nil {
+		return "", err
+	}
+
+	//The cache is shared by all the releases
+	cache := NewChartCache(filepath.Join(chartsDir, ChartCacheDirName))
+
+	return downloadChartFromOCI(ctx, configMap, secret, cache, destRepo, s)
+}
+
+func downloadChartFromOCI(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	cache *ChartCache,
+	destRepo string,
+	s *appv1alpha1.HelmRelease) (chartDir string, err error) {
+	if s.Spec.Source.OCI == nil {
+		err := fmt.Errorf("oci type but Spec.OCI is not defined")
+		return "", err
+	}
+
+	for _, urlelem := range s.Spec.Source.OCI.Urls {
+		var httpClient *http.Client
+
+		httpClient, err = getHTTPClient(urlelem, configMap, secret)
+		if err != nil {
+			klog.Error(err, " - Failed to create httpClient")
+			return "", err
+		}
+
+		chartDir, err = downloadOCIChart(ctx, httpClient, secret, cache, urlelem, s.Spec.Digest, destRepo)
+		if err != nil {
+			klog.Error(err, " - url: ", urlelem)
+			continue
+		}
+
+		return chartDir, nil
+	}
+
+	return "", err
+}
+
+//downloadOCIChart streams the chart layer of the manifest into destRepo, the layer is looked up in
+//the cache by its digest and must match the digest of the HelmRelease if set
+func downloadOCIChart(ctx context.Context,
+	httpClient rest.HTTPClient,
+	secret *corev1.Secret,
+	cache *ChartCache,
+	ociURL string,
+	digest string,
+	destRepo string) (chartDir string, err error) {
+	ref, err := ParseOCIReference(ociURL)
+	if err != nil {
+		return "", err
+	}
+
+	manifest, _, err := getOCIManifest(ctx, httpClient, secret, ref)
+	if err != nil {
+		return "", err
+	}
+
+	layer := chartLayer(manifest)
+	if layer == nil {
+		return "", fmt.Errorf("no helm chart layer found in %s", ref)
+	}
+
+	layerDigest := strings.ToLower(strings.TrimPrefix(layer.Digest, "sha256:"))
+	if digest = strings.ToLower(strings.TrimPrefix(digest, "sha256:")); digest != "" && digest != layerDigest {
+		return "", &ChartDigestError{URL: ociURL, Expected: digest, Actual: layerDigest}
+	}
+
+	maxSize := maxDownloadSize()
+	if layer.Size > maxSize {
+		return "", fmt.Errorf("%w: %s exceeds %d bytes", ErrDownloadTooLarge, ref, maxSize)
+	}
+
+	blobURL := ref.apiURL("blobs", layer.Digest)
+
+	//The chart directory is named after the Chart.yaml, it can differ from the repository
+	chartName, err := streamChartArchive(cache, func() (io.ReadCloser, error) {
+		resp, err := ociGet(ctx, httpClient, secret, blobURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return newLimitedReadCloser(resp.Body, blobURL, maxSize), nil
+	}, blobURL, ref.ChartName()+".tgz", layer.Digest, destRepo)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(destRepo, chartName), nil
+}
+
+//chartLayer returns the layer of the manifest holding the chart archive
+func chartLayer(manifest *ociManifest) *ociDescriptor {
+	for i := range manifest.Layers {
+		if manifest.Layers[i].MediaType == HelmChartContentLayerMediaType ||
+			manifest.Layers[i].MediaType == helmChartLegacyLayerMediaType {
+			return &manifest.Layers[i]
+		}
+	}
+
+	return nil
+}
+
+//getOCIManifest returns the manifest of the reference and its digest
+func getOCIManifest(ctx context.Context,
+	httpClient rest.HTTPClient,
+	secret *corev1.Secret,
+	ref *OCIReference) (manifest *ociManifest, digest string, err error) {
+	resp, err := ociGet(ctx, httpClient, secret, ref.apiURL("manifests", ref.Reference()), ociManifestMediaType)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	digest = sha256Digest(body)
+
+	if ref.Digest != "" && digest != ref.Digest {
+		return nil, "", fmt.Errorf("manifest digest mismatch for %s: got %s", ref, digest)
+	}
+
+	manifest = &ociManifest{}
+
+	err = json.Unmarshal(body, manifest)
+	if err != nil {
+		klog.Error(err, " - Unable to parse the manifest of ", ref)
+		return nil, "", err
+	}
+
+	return manifest, digest, nil
+}
+
+//GetOCIIndex lists the tags of the OCI repositories and builds a repo.IndexFile out of them,
+//the tags which are not a semver version are ignored. The tags are mutable, the charts kept
+//are pinned to the digest of their manifest by ResolveOCIDigests.
+func GetOCIIndex(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	parentNamespace string,
+	urls []string) (indexFile *repo.IndexFile, err error) {
+	indexFile = repo.NewIndexFile()
+	succeeded := false
+
+	for _, urlelem := range urls {
+		var ref *OCIReference
+
+		ref, err = ParseOCIReference(urlelem)
+		if err != nil {
+			klog.Error(err, " - Invalid oci url ", urlelem)
+			continue
+		}
+
+		var httpClient *http.Client
+
+		httpClient, err = getHTTPClient(urlelem, configMap, secret)
+		if err != nil {
+			klog.Error(err, " - Unable to create client for oci registry ", urlelem)
+			return nil, err
+		}
+
+		var tags []string
+
+		tags, err = listOCITags(ctx, httpClient, secret, ref)
+		if err != nil {
+			klog.Error(err, " - Unable to list tags of ", urlelem)
+			continue
+		}
+
+		succeeded = true
+
+		for _, tag := range tags {
+			//helm replaces the '+' of the version by '_' as it is not allowed in a tag
+			version := strings.Replace(tag, "_", "+", -1)
+			if _, err := semver.Parse(version); err != nil {
+				klog.V(5).Info("Ignoring tag ", tag, " of ", urlelem)
+				continue
+			}
+
+			if indexFile.Has(ref.ChartName(), version) {
+				continue
+			}
+
+			chartRef := &OCIReference{Registry: ref.Registry, Repository: ref.Repository, Tag: tag}
+			indexFile.Add(&chart.Metadata{
+				ApiVersion: "v1",
+				Name:       ref.ChartName(),
+				Version:    version,
+			}, chartRef.String(), "", "")
+		}
+	}
+
+	if !succeeded {
+		klog.Error(err, " - All oci urls tested and all failed")
+		return nil, err
+	}
+
+	indexFile.SortEntries()
+
+	return indexFile, nil
+}
+
+//ResolveOCIDigests pins the urls of the chart versions of the index to the digest of their manifest
+//and sets the digest of the chart layer, the returned hash changes when a tag is pushed again.
+//It is called once the index is filtered as each tag costs a request to the registry.
+func ResolveOCIDigests(ctx context.Context,
+	configMap *corev1.ConfigMap,
+	secret *corev1.Secret,
+	indexFile *repo.IndexFile) (hash string, err error) {
+	refs := make([]string, 0)
+
+	for _, chartVersions := range indexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			for i, urlelem := range chartVersion.URLs {
+				ref, err := ParseOCIReference(urlelem)
+				if err != nil {
+					return "", err
+				}
+
+				httpClient, err := getHTTPClient(urlelem, configMap, secret)
+				if err != nil {
+					klog.Error(err, " - Unable to create client for oci registry ", urlelem)
+					return "", err
+				}
+
+				manifest, digest, err := getOCIManifest(ctx, httpClient, secret, ref)
+				if err != nil {
+					klog.Error(err, " - Unable to resolve the digest of ", urlelem)
+					return "", err
+				}
+
+				layer := chartLayer(manifest)
+				if layer == nil {
+					return "", fmt.Errorf("no helm chart layer found in %s", ref)
+				}
+
+				ref.Digest = digest
+				chartVersion.URLs[i] = ref.String()
+				chartVersion.Digest = strings.TrimPrefix(layer.Digest, "sha256:")
+				refs = append(refs, chartVersion.URLs[i])
+			}
+		}
+	}
+
+	sort.Strings(refs)
+
+	hash, err = HashKey([]byte(strings.Join(refs, "\n")))
+	if err != nil {
+		klog.Error(err, " - Unable to generate hashkey")
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func listOCITags(ctx context.Context, httpClient rest.HTTPClient, secret *corev1.Secret, ref *OCIReference) ([]string, error) {
+	tags := make([]string, 0)
+	next := ref.apiURL("tags", "list")
+
+	for page := 0; next != ""; page++ {
+		if page == ociMaxTagPages {
+			return nil, fmt.Errorf("more than %d pages of tags listed for %s", ociMaxTagPages, ref)
+		}
+
+		resp, err := ociGet(ctx, httpClient, secret, next)
+		if err != nil {
+			return nil, err
+		}
+
+		tagList := &ociTagList{}
+		err = json.NewDecoder(resp.Body).Decode(tagList)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, tagList.Tags...)
+		next = nextOCILink(ref, resp.Header.Get("Link"))
+	}
+
+	return tags, nil
+}
+
+//nextOCILink parses the pagination header: Link: </v2/<name>/tags/list?n=2&last=b>; rel="next"
+func nextOCILink(ref *OCIReference, link string) string {
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+
+	if start < 0 || end < start {
+		return ""
+	}
+
+	next := link[start+1 : end]
+	if strings.HasPrefix(next, "/") {
+		next = "https://" + ref.Registry + next
+	}
+
+	return next
+}
+
+//ociGet sends a GET to the registry, the credentials of the secret are only used to answer the challenge
+//of the registry: they are sent as requested by a basic challenge or used to retrieve the token of a
+//bearer challenge, the request is then sent again.
+func ociGet(ctx context.Context, httpClient rest.HTTPClient, secret *corev1.Secret, reqURL string, accept ...string) (*http.Response, error) {
+	req, err := newOCIRequest(ctx, reqURL, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doWithRetry(httpClient, req)
+	if err != nil {
+		klog.Error(err, " - Http request failed: ", reqURL)
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		auth, err := GetAuthProvider(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err = newOCIRequest(ctx, reqURL, accept)
+		if err != nil {
+			return nil, err
+		}
+
+		switch scheme := strings.ToLower(challenge); {
+		case strings.HasPrefix(scheme, "bearer "):
+			token, err := getOCIBearerToken(ctx, httpClient, auth, challenge)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("Authorization", "Bearer "+token)
+		case strings.HasPrefix(scheme, "basic "):
+			err = auth.Authenticate(httpClient, req)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, newStatusError(reqURL, resp.StatusCode)
+		}
+
+		resp, err = doWithRetry(httpClient, req)
+		if err != nil {
+			klog.Error(err, " - Http request failed: ", reqURL)
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, newStatusError(reqURL, resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func newOCIRequest(ctx context.Context, reqURL string, accept []string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		klog.Error(err, " - Can not build request: ", reqURL)
+		return nil, err
+	}
+
+	for _, a := range accept {
+		req.Header.Add("Accept", a)
+	}
+
+	return req, nil
+}
+
+//getOCIBearerToken retrieves a token from the realm of the challenge, the request is authenticated by the auth provider
+//Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts/app:pull"
+func getOCIBearerToken(ctx context.Context, httpClient rest.HTTPClient, auth AuthProvider, challenge string) (string, error) {
+	params := make(map[string]string)
+	for _, m := range ociChallengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("no realm in challenge %s", challenge)
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+
+	q := tokenURL.Query()
+
+	for _, k := range []string{"service", "scope"} {
+		if v, ok := params[k]; ok {
+			q.Set(k, v)
+		}
+	}
+
+	tokenURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	err = auth.Authenticate(httpClient, req)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := doWithRetry(httpClient, req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newStatusError(realm, resp.StatusCode)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("no token returned by %s", realm)
+}
+
+func sha256Digest(b []byte) string {
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
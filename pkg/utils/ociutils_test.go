@@ -0,0 +1,298 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+const ociRepository = "charts/subscription-release-test-1"
+
+//newOCIRegistry starts a local registry stand-in serving subscription-release-test-1 0.1.0,
+//the tag is pushed again with the config when it changes
+func newOCIRegistry(t *testing.T, config *[]byte) (server *httptest.Server, manifestDigest string) {
+	chartArchive, err := ioutil.ReadFile("../../test/helmrepo/subscription-release-test-1-0.1.0.tgz")
+	assert.NoError(t, err)
+
+	layerDigest := sha256Digest(chartArchive)
+
+	newManifest := func() []byte {
+		manifest, err := json.Marshal(ociManifest{
+			SchemaVersion: 2,
+			Config: ociDescriptor{
+				MediaType: "application/vnd.cncf.helm.config.v1+json",
+				Digest:    sha256Digest(*config),
+				Size:      int64(len(*config)),
+			},
+			Layers: []ociDescriptor{
+				{
+					MediaType: HelmChartContentLayerMediaType,
+					Digest:    layerDigest,
+					Size:      int64(len(chartArchive)),
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		return manifest
+	}
+
+	manifestDigest = sha256Digest(newManifest())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/"+ociRepository+"/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(ociTagList{Name: ociRepository, Tags: []string{"0.1.0", "latest"}})
+	})
+	mux.HandleFunc("/v2/"+ociRepository+"/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		manifest := newManifest()
+		ref := strings.TrimPrefix(r.URL.Path, "/v2/"+ociRepository+"/manifests/")
+		if ref != "0.1.0" && ref != sha256Digest(manifest) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", ociManifestMediaType)
+		_, _ = w.Write(manifest)
+	})
+	mux.HandleFunc("/v2/"+ociRepository+"/blobs/"+layerDigest, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(chartArchive)
+	})
+
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+
+	return server, manifestDigest
+}
+
+func ociTestConfig() (*corev1.ConfigMap, *corev1.Secret) {
+	configMap := &corev1.ConfigMap{
+		Data: map[string]string{
+			"insecureSkipVerify": "true",
+		},
+	}
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			"user":     []byte("user"),
+			"password": []byte("password"),
+		},
+	}
+
+	return configMap, secret
+}
+
+func TestParseOCIReference(t *testing.T) {
+	ref, err := ParseOCIReference("oci://registry.example.com:5000/charts/mychart:1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.example.com:5000", ref.Registry)
+	assert.Equal(t, "charts/mychart", ref.Repository)
+	assert.Equal(t, "1.2.3", ref.Tag)
+	assert.Equal(t, "mychart", ref.ChartName())
+	assert.Equal(t, "1.2.3", ref.Reference())
+
+	ref, err = ParseOCIReference("oci://registry.example.com/mychart@sha256:abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, "mychart", ref.Repository)
+	assert.Equal(t, "", ref.Tag)
+	assert.Equal(t, "sha256:abcd", ref.Reference())
+	assert.Equal(t, "oci://registry.example.com/mychart@sha256:abcd", ref.String())
+
+	_, err = ParseOCIReference("https://registry.example.com/mychart")
+	assert.Error(t, err)
+
+	_, err = ParseOCIReference("oci://registry.example.com")
+	assert.Error(t, err)
+}
+
+func TestGetOCIIndex(t *testing.T) {
+	config := []byte("{}")
+
+	server, manifestDigest := newOCIRegistry(t, &config)
+	defer server.Close()
+
+	configMap, secret := ociTestConfig()
+	registry := strings.TrimPrefix(server.URL, "https://")
+	urls := []string{"oci://" + registry + "/" + ociRepository}
+
+	indexFile, err := GetOCIIndex(context.TODO(), configMap, secret, "default", urls)
+	assert.NoError(t, err)
+
+	chartVersions := indexFile.Entries["subscription-release-test-1"]
+	assert.Equal(t, 1, len(chartVersions))
+	assert.Equal(t, "0.1.0", chartVersions[0].GetVersion())
+	assert.Equal(t, "oci://"+registry+"/"+ociRepository+":0.1.0", chartVersions[0].URLs[0])
+
+	//The tags are pinned to the digest of their manifest
+	hash, err := ResolveOCIDigests(context.TODO(), configMap, secret, indexFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", hash)
+	assert.Equal(t, "oci://"+registry+"/"+ociRepository+":0.1.0@"+manifestDigest, chartVersions[0].URLs[0])
+	assert.Equal(t, "2b9ada622755a18b6b9ab72e942f819bf7c2ba7362f15d8e8bf8056429f38769", chartVersions[0].Digest)
+
+	//A tag pushed again changes the hash
+	config = []byte(`{"name": "subscription-release-test-1"}`)
+
+	indexFile, err = GetOCIIndex(context.TODO(), configMap, secret, "default", urls)
+	assert.NoError(t, err)
+
+	pushedHash, err := ResolveOCIDigests(context.TODO(), configMap, secret, indexFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, pushedHash)
+	assert.NotContains(t, indexFile.Entries["subscription-release-test-1"][0].URLs[0], manifestDigest)
+
+	_, err = GetOCIIndex(context.TODO(), configMap, nil, "default", urls)
+	assert.Error(t, err)
+}
+
+func TestDownloadChartFromOCI(t *testing.T) {
+	config := []byte("{}")
+
+	server, manifestDigest := newOCIRegistry(t, &config)
+	defer server.Close()
+
+	configMap, secret := ociTestConfig()
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	for _, ref := range []string{
+		"oci://" + registry + "/" + ociRepository + ":0.1.0",
+		"oci://" + registry + "/" + ociRepository + "@" + manifestDigest,
+	} {
+		hr := &appv1alpha1.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "subscription-release-test-1-cr",
+				Namespace: "default",
+			},
+			Spec: appv1alpha1.HelmReleaseSpec{
+				Source: &appv1alpha1.Source{
+					SourceType: appv1alpha1.OCISourceType,
+					OCI: &appv1alpha1.OCI{
+						Urls: []string{ref},
+					},
+				},
+				ChartName:   "subscription-release-test-1",
+				ReleaseName: "subscription-release-test-1",
+			},
+		}
+		dir, err := ioutil.TempDir("/tmp", "charts")
+		assert.NoError(t, err)
+
+		chartDir, err := DownloadChart(context.TODO(), configMap, secret, nil, dir, hr)
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+		assert.NoError(t, err)
+
+		//Only the chart is left next to the chart directory, the archive is in the cache
+		entries, err := ioutil.ReadDir(filepath.Dir(chartDir))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(entries))
+
+		_, err = os.Stat(filepath.Join(dir, ChartCacheDirName, "2b9ada622755a18b6b9ab72e942f819bf7c2ba7362f15d8e8bf8056429f38769"))
+		assert.NoError(t, err)
+
+		//The chart must match the digest of the HelmRelease
+		hr.Spec.Digest = "0000"
+
+		_, err = DownloadChart(context.TODO(), configMap, secret, nil, dir, hr)
+		assert.Error(t, err)
+
+		os.RemoveAll(dir)
+	}
+
+	httpClient, err := GetHelmRepoClient("default", configMap, secret)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	cache := NewChartCache(filepath.Join(dir, ChartCacheDirName))
+
+	_, err = downloadOCIChart(context.TODO(), httpClient, secret, cache, "oci://"+registry+"/"+ociRepository+"@sha256:0000", "", dir)
+	assert.Error(t, err)
+
+	os.Setenv(appv1alpha1.ChartsMaxDownloadSize, "1Ki")
+	defer os.Unsetenv(appv1alpha1.ChartsMaxDownloadSize)
+
+	_, err = downloadOCIChart(context.TODO(), httpClient, secret, cache, "oci://"+registry+"/"+ociRepository+":0.1.0", "", dir)
+	assert.True(t, errors.Is(err, ErrDownloadTooLarge))
+}
+
+func TestOCIBearerChallenge(t *testing.T) {
+	var server *httptest.Server
+
+	anonymous := 0
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			//The token request is authenticated with the credentials of the secret
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, "repository:"+ociRepository+":pull", r.URL.Query().Get("scope"))
+			_, _ = w.Write([]byte(`{"token": "registry-token"}`))
+
+			return
+		}
+
+		switch r.Header.Get("Authorization") {
+		case "Bearer registry-token":
+			_ = json.NewEncoder(w).Encode(ociTagList{Name: ociRepository, Tags: []string{"0.1.0"}})
+		case "":
+			anonymous++
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="`+server.URL+`/token",service="registry",scope="repository:`+ociRepository+`:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			//The credentials are only sent to the token service
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+
+	defer server.Close()
+
+	configMap, secret := ociTestConfig()
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	indexFile, err := GetOCIIndex(context.TODO(), configMap, secret, "default", []string{"oci://" + registry + "/" + ociRepository})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(indexFile.Entries["subscription-release-test-1"]))
+
+	//The first request is sent without credentials
+	assert.Equal(t, 1, anonymous)
+}
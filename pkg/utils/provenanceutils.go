@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/helm/pkg/provenance"
+	"k8s.io/klog"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+//KeyringSecretKey key in the keyring secret holding the public keyring of the signers
+const KeyringSecretKey = "keyring"
+
+//ChartVerificationError is returned when the chart is not signed or the signature is not valid
+type ChartVerificationError struct {
+	URL string
+	Err error
+}
+
+func (e *ChartVerificationError) Error() string {
+	return fmt.Sprintf("chart verification failed for %s: %v", e.URL, e.Err)
+}
+
+func (e *ChartVerificationError) Unwrap() error {
+	return e.Err
+}
+
+//IsProvenanceVerificationRequired returns true if the chart signature must be verified
+func IsProvenanceVerificationRequired(verification *appv1alpha1.ChartVerification) bool {
+	return verification != nil &&
+		strings.EqualFold(string(verification.Mode), string(appv1alpha1.VerificationModeProvenance))
+}
+
+//getKeyring reads the public keyring from the secret, binary or armored
+func getKeyring(keyringSecret *corev1.Secret) (openpgp.EntityList, error) {
+	if keyringSecret == nil || keyringSecret.Data == nil {
+		return nil, fmt.Errorf("a keyring secret is required to verify the chart")
+	}
+
+	keyring, ok := keyringSecret.Data[KeyringSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in secret %s", KeyringSecretKey, keyringSecret.Name)
+	}
+
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(keyring))
+	if err != nil {
+		klog.V(5).Info("Keyring is not binary, reading it as armored")
+
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the keyring of secret %s: %v", keyringSecret.Name, err)
+		}
+	}
+
+	return entities, nil
+}
+
+//verifyChartProvenance verifies the chart archive against its provenance file and the keyring
+func verifyChartProvenance(keyringSecret *corev1.Secret, chartURL string, chartZip string, provFile string) error {
+	keyring, err := getKeyring(keyringSecret)
+	if err != nil {
+		return &ChartVerificationError{URL: chartURL, Err: err}
+	}
+
+	signatory := &provenance.Signatory{KeyRing: keyring}
+
+	verification, err := signatory.Verify(chartZip, provFile)
+	if err != nil {
+		return &ChartVerificationError{URL: chartURL, Err: err}
+	}
+
+	klog.V(3).Info("Chart ", chartURL, " signed by ", verification.SignedBy.PrimaryKey.KeyIdString())
+
+	return nil
+}
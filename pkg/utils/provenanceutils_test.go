@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/provenance"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+//newKeyringSecret creates a signer and returns its public keyring in a secret
+func newKeyringSecret(t *testing.T) (*openpgp.Entity, *corev1.Secret) {
+	signer, err := openpgp.NewEntity("release team", "", "release@example.com", nil)
+	assert.NoError(t, err)
+
+	keyring := &bytes.Buffer{}
+	err = signer.Serialize(keyring)
+	assert.NoError(t, err)
+
+	return signer, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keyring"},
+		Data: map[string][]byte{
+			KeyringSecretKey: keyring.Bytes(),
+		},
+	}
+}
+
+func TestDownloadChartVerified(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "charts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	//The chart and its provenance file are served from a local directory
+	repoDir := filepath.Join(dir, "repo")
+	err = os.MkdirAll(repoDir, 0755)
+	assert.NoError(t, err)
+
+	chartArchive, err := ioutil.ReadFile("../../test/helmrepo/subscription-release-test-1-0.1.0.tgz")
+	assert.NoError(t, err)
+
+	chartZip := filepath.Join(repoDir, "subscription-release-test-1-0.1.0.tgz")
+	err = ioutil.WriteFile(chartZip, chartArchive, 0644)
+	assert.NoError(t, err)
+
+	hr := &appv1alpha1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "subscription-release-test-1-cr",
+			Namespace: "default",
+		},
+		Spec: appv1alpha1.HelmReleaseSpec{
+			Source: &appv1alpha1.Source{
+				SourceType: appv1alpha1.HelmRepoSourceType,
+				HelmRepo: &appv1alpha1.HelmRepo{
+					Urls: []string{"file:" + chartZip},
+				},
+			},
+			ChartName:   "subscription-release-test-1",
+			ReleaseName: "subscription-release-test-1",
+			Verification: &appv1alpha1.ChartVerification{
+				Mode: appv1alpha1.VerificationModeProvenance,
+			},
+		},
+	}
+
+	signer, keyringSecret := newKeyringSecret(t)
+
+	//Unsigned chart
+	_, err = DownloadChart(context.TODO(), nil, nil, keyringSecret, filepath.Join(dir, "charts"), hr)
+	assert.Error(t, err)
+
+	_, ok := err.(*ChartVerificationError)
+	assert.True(t, ok)
+
+	sig, err := (&provenance.Signatory{Entity: signer}).ClearSign(chartZip)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(chartZip+".prov", []byte(sig), 0644)
+	assert.NoError(t, err)
+
+	chartDir, err := DownloadChart(context.TODO(), nil, nil, keyringSecret, filepath.Join(dir, "charts"), hr)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(chartDir, "Chart.yaml"))
+	assert.NoError(t, err)
+
+	//Signed by another key
+	_, otherKeyringSecret := newKeyringSecret(t)
+
+	_, err = DownloadChart(context.TODO(), nil, nil, otherKeyringSecret, filepath.Join(dir, "charts"), hr)
+	assert.Error(t, err)
+
+	_, ok = err.(*ChartVerificationError)
+	assert.True(t, ok)
+
+	//No keyring
+	_, err = DownloadChart(context.TODO(), nil, nil, nil, filepath.Join(dir, "charts"), hr)
+	assert.Error(t, err)
+
+	//Not supported for github
+	hr.Spec.Source = &appv1alpha1.Source{
+		SourceType: appv1alpha1.GitHubSourceType,
+		GitHub: &appv1alpha1.GitHub{
+			Urls: []string{"https://github.com/IBM/multicloud-operators-subscription-release.git"},
+		},
+	}
+
+	_, err = DownloadChart(context.TODO(), nil, nil, keyringSecret, filepath.Join(dir, "charts"), hr)
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	//HTTPProxyConfigMapKey key in the configMap holding the proxy of the http requests
+	HTTPProxyConfigMapKey = "httpProxy"
+	//HTTPSProxyConfigMapKey key in the configMap holding the proxy of the https requests
+	HTTPSProxyConfigMapKey = "httpsProxy"
+	//NoProxyConfigMapKey key in the configMap holding the comma separated hosts accessed directly, "*" for all
+	NoProxyConfigMapKey = "noProxy"
+	//ProxyUserSecretKey key in the secret holding the user to authenticate against the proxy
+	ProxyUserSecretKey = "proxyUser"
+	//ProxyPasswordSecretKey key in the secret holding the password to authenticate against the proxy
+	ProxyPasswordSecretKey = "proxyPassword"
+)
+
+//getProxy returns the proxy function of the repository, the proxy settings of the configMap
+//replace the ones of the process environment when at least one of them is set. The credentials
+//of the secret are added to the proxy urls, whether they come from the configMap or the environment.
+func getProxy(configMap *corev1.ConfigMap, secret *corev1.Secret) (func(*http.Request) (*url.URL, error), error) {
+	proxyConfig := httpproxy.FromEnvironment()
+	httpProxyName, httpsProxyName := "HTTP_PROXY", "HTTPS_PROXY"
+
+	if configMap != nil {
+		httpProxy, hasHTTPProxy := configMap.Data[HTTPProxyConfigMapKey]
+		httpsProxy, hasHTTPSProxy := configMap.Data[HTTPSProxyConfigMapKey]
+		noProxy, hasNoProxy := configMap.Data[NoProxyConfigMapKey]
+
+		if hasHTTPProxy || hasHTTPSProxy || hasNoProxy {
+			klog.V(5).Info("Use the proxy of configMap ", configMap.Name, ", noProxy: ", noProxy)
+
+			proxyConfig = &httpproxy.Config{
+				HTTPProxy:  httpProxy,
+				HTTPSProxy: httpsProxy,
+				NoProxy:    noProxy,
+			}
+			httpProxyName = fmt.Sprintf("%s in configMap %s", HTTPProxyConfigMapKey, configMap.Name)
+			httpsProxyName = fmt.Sprintf("%s in configMap %s", HTTPSProxyConfigMapKey, configMap.Name)
+		}
+	}
+
+	var err error
+
+	if secret != nil && secret.Data != nil {
+		if user, ok := secret.Data[ProxyUserSecretKey]; ok {
+			password := string(secret.Data[ProxyPasswordSecretKey])
+
+			proxyConfig.HTTPProxy, err = withProxyUser(proxyConfig.HTTPProxy, string(user), password)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", httpProxyName, err)
+			}
+
+			proxyConfig.HTTPSProxy, err = withProxyUser(proxyConfig.HTTPSProxy, string(user), password)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", httpsProxyName, err)
+			}
+		}
+	}
+
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+//withProxyUser sets the credentials in the proxy url, they are sent in the Proxy-Authorization header
+func withProxyUser(proxy string, user string, password string) (string, error) {
+	if proxy == "" {
+		return "", nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		//The scheme is optional, http by default
+		proxyURL, err = url.Parse("http://" + proxy)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	proxyURL.User = url.UserPassword(user, password)
+
+	return proxyURL.String(), nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+var (
+	//retryMaxAttempts is the number of attempts of a repository request
+	retryMaxAttempts = 4
+	//retryBaseDelay is the delay before the first retry, it doubles at each retry
+	retryBaseDelay = 500 * time.Millisecond
+	//retryMaxDelay caps the delay between two attempts, including the Retry-After of the server
+	retryMaxDelay = 30 * time.Second
+)
+
+//RepositoryError is returned when a request to a repository failed, a transient error
+//is expected to succeed later while a permanent error requires a change of the settings.
+type RepositoryError struct {
+	URL        string
+	StatusCode int
+	Transient  bool
+	Err        error
+}
+
+func (e *RepositoryError) Error() string {
+	class := "permanent"
+	if e.Transient {
+		class = "transient"
+	}
+
+	if e.Err != nil {
+		return fmt.Sprintf("%s error: request to %s failed: %v", class, e.URL, e.Err)
+	}
+
+	return fmt.Sprintf("%s error: %s returned %d %s", class, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *RepositoryError) Unwrap() error {
+	return e.Err
+}
+
+//IsTransientError returns true if the error is a transient RepositoryError
+func IsTransientError(err error) bool {
+	var repoErr *RepositoryError
+
+	return errors.As(err, &repoErr) && repoErr.Transient
+}
+
+//newStatusError returns the RepositoryError of an unexpected response status
+func newStatusError(url string, statusCode int) error {
+	return &RepositoryError{URL: url, StatusCode: statusCode, Transient: isTransientStatus(statusCode)}
+}
+
+//isTransientStatus returns true for the statuses a server returns when overloaded or unavailable
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+//isTransientTransportError returns false for the certificate and TLS errors which fail until the settings are fixed
+func isTransientTransportError(err error) bool {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+
+	var certificateInvalidErr x509.CertificateInvalidError
+
+	var hostnameErr x509.HostnameError
+
+	switch {
+	case errors.As(err, &unknownAuthorityErr), errors.As(err, &certificateInvalidErr), errors.As(err, &hostnameErr):
+		return false
+	default:
+		//The TLS alerts are not typed
+		return !strings.Contains(err.Error(), "tls:")
+	}
+}
+
+//doWithRetry sends the request until the response status is not transient, the attempts are
+//spaced by a jittered exponential backoff or by the Retry-After of the server. The response of
+//the last attempt is returned whatever its status, the transport errors are returned as
+//RepositoryError. The retries stop when the context of the request is cancelled.
+//The request must not have a body.
+func doWithRetry(httpClient rest.HTTPClient, req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+
+	for attempt := 1; ; attempt++ {
+		resp, err := httpClient.Do(req)
+
+		if err != nil && (attempt >= retryMaxAttempts || req.Context().Err() != nil || !isTransientTransportError(err)) {
+			return nil, &RepositoryError{URL: url, Transient: isTransientTransportError(err), Err: err}
+		}
+
+		if attempt >= retryMaxAttempts {
+			return resp, nil
+		}
+
+		var delay time.Duration
+
+		switch {
+		case err != nil:
+			delay = retryDelay(attempt, nil)
+			klog.Info(err, " - Request to ", url, " failed, retrying in ", delay)
+		case isTransientStatus(resp.StatusCode):
+			delay = retryDelay(attempt, resp)
+			klog.Info(url, " returned ", resp.Status, ", retrying in ", delay)
+
+			//Drain the body to reuse the connection
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, &RepositoryError{URL: url, Transient: true, Err: req.Context().Err()}
+		case <-time.After(delay):
+		}
+	}
+}
+
+//retryDelay returns the Retry-After of the response if any, a jittered exponential backoff otherwise
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if d > retryMaxDelay {
+				return retryMaxDelay
+			}
+
+			return d
+		}
+	}
+
+	d := retryBaseDelay << uint(attempt-1)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+
+	return wait.Jitter(d/2, 1)
+}
+
+//parseRetryAfter parses the delay in seconds or the HTTP date of the Retry-After header
+func parseRetryAfter(retryAfter string) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		d := time.Until(date)
+		if d < 0 {
+			d = 0
+		}
+
+		return d, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	d, ok := parseRetryAfter("2")
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, d)
+
+	d, ok = parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.True(t, d > 50*time.Second && d <= time.Minute)
+
+	_, ok = parseRetryAfter("soon")
+	assert.False(t, ok)
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3600"}}}
+	assert.Equal(t, retryMaxDelay, retryDelay(1, resp))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		backoff := retryBaseDelay << uint(attempt-1)
+		d := retryDelay(attempt, nil)
+		assert.True(t, d >= backoff/2 && d <= backoff, d)
+	}
+
+	assert.True(t, retryDelay(100, nil) <= retryMaxDelay)
+}
+
+func TestGetHelmIndexRetry(t *testing.T) {
+	baseDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+
+	defer func() {
+		retryBaseDelay = baseDelay
+	}()
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/unavailable"):
+			if attempts < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(mirrorIndex))
+		case strings.HasPrefix(r.URL.Path, "/throttled"):
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	defer server.Close()
+
+	indexFile, _, _, err := GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{server.URL + "/unavailable"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 1, len(indexFile.Entries))
+
+	attempts = 0
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{server.URL + "/throttled"}, "")
+	assert.Error(t, err)
+	assert.Equal(t, retryMaxAttempts, attempts)
+	assert.True(t, IsTransientError(err))
+	assert.Contains(t, err.Error(), "transient error")
+
+	//A permanent error is not retried
+	attempts = 0
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), nil, nil, "", []string{server.URL + "/notfound"}, "")
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.False(t, IsTransientError(err))
+	assert.Contains(t, err.Error(), "permanent error")
+
+	attempts = 0
+	err = fetchFile(context.TODO(), "", nil, server.URL+"/throttled/chart.tgz", nil, "/tmp/chart.tgz")
+	assert.Equal(t, retryMaxAttempts, attempts)
+	assert.True(t, IsTransientError(err))
+}
+
+func TestGetHelmIndexCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hung/index.yaml":
+			<-release
+		default:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	defer server.Close()
+	defer close(release)
+
+	//A hung repository and the wait between two attempts are interrupted by the cancellation
+	for _, path := range []string{"/hung", "/unavailable"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+
+		_, _, _, err := GetHelmRepoIndex(ctx, nil, nil, "", []string{server.URL + path}, "")
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < 5*time.Second, path)
+		assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+
+		cancel()
+	}
+}
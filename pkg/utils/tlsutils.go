@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	//InsecureSkipVerifyConfigMapKey key in the configMap disabling the server certificate verification
+	InsecureSkipVerifyConfigMapKey = "insecureSkipVerify"
+	//CABundleConfigMapKey key in the configMap holding the PEM encoded CA certificates trusted for the repository
+	CABundleConfigMapKey = "caBundle"
+	//ClientCertSecretKey key in the secret holding the PEM encoded client certificate
+	ClientCertSecretKey = corev1.TLSCertKey
+	//ClientKeySecretKey key in the secret holding the PEM encoded client private key
+	ClientKeySecretKey = corev1.TLSPrivateKeyKey
+)
+
+//getTLSConfig builds the TLS configuration of the repository, the CA bundle of the configMap
+//is trusted in addition to the system CAs and the client certificate of the secret is presented
+//to the servers which request it.
+func getTLSConfig(configMap *corev1.ConfigMap, secret *corev1.Secret) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+
+	if configMap != nil {
+		configData := configMap.Data
+		klog.V(5).Info("ConfigRef retrieved :", configData)
+
+		if insecureSkipVerify := configData[InsecureSkipVerifyConfigMapKey]; insecureSkipVerify != "" {
+			b, err := strconv.ParseBool(insecureSkipVerify)
+			if err != nil {
+				klog.Error(err, " - Unable to parse insecureSkipVerify", insecureSkipVerify)
+				return nil, err
+			}
+
+			klog.V(5).Info("Set InsecureSkipVerify: ", b)
+			tlsConfig.InsecureSkipVerify = b
+		} else {
+			klog.V(5).Info("insecureSkipVerify is not specified")
+		}
+
+		if caBundle := configData[CABundleConfigMapKey]; caBundle != "" {
+			rootCAs, err := x509.SystemCertPool()
+			if err != nil {
+				klog.V(3).Info(err, " - Unable to load the system CAs, only the caBundle is trusted")
+
+				rootCAs = x509.NewCertPool()
+			}
+
+			if !rootCAs.AppendCertsFromPEM([]byte(caBundle)) {
+				return nil, fmt.Errorf("no PEM encoded certificate found in %s of configMap %s", CABundleConfigMapKey, configMap.Name)
+			}
+
+			klog.V(5).Info("Add the caBundle of configMap ", configMap.Name)
+			tlsConfig.RootCAs = rootCAs
+		}
+	} else {
+		klog.V(5).Info("configMap is nil")
+	}
+
+	if secret != nil && secret.Data != nil {
+		cert, hasCert := secret.Data[ClientCertSecretKey]
+		key, hasKey := secret.Data[ClientKeySecretKey]
+
+		switch {
+		case hasCert && hasKey:
+			clientCert, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				klog.Error(err, " - Unable to load the client certificate of secret ", secret.Name)
+				return nil, err
+			}
+
+			klog.V(5).Info("Add the client certificate of secret ", secret.Name)
+			tlsConfig.Certificates = []tls.Certificate{clientCert}
+		case hasCert || hasKey:
+			return nil, fmt.Errorf("both %s and %s are required in secret %s", ClientCertSecretKey, ClientKeySecretKey, secret.Name)
+		}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//newClientCertificate returns a self signed client certificate and its key, PEM encoded
+func newClientCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM
+}
+
+//newMTLSIndexServer starts a server trusting only the client certificate and serving the index.yaml
+func newMTLSIndexServer(t *testing.T, clientCertPEM []byte) (server *httptest.Server, caBundle string) {
+	clientCAs := x509.NewCertPool()
+	assert.True(t, clientCAs.AppendCertsFromPEM(clientCertPEM))
+
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(mirrorIndex))
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+
+	caBundle = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	return server, caBundle
+}
+
+func TestGetTLSConfig(t *testing.T) {
+	tlsConfig, err := getTLSConfig(nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Empty(t, tlsConfig.Certificates)
+
+	_, err = getTLSConfig(&corev1.ConfigMap{Data: map[string]string{CABundleConfigMapKey: "not a certificate"}}, nil)
+	assert.Error(t, err)
+
+	certPEM, keyPEM := newClientCertificate(t)
+
+	_, err = getTLSConfig(nil, &corev1.Secret{Data: map[string][]byte{ClientCertSecretKey: certPEM}})
+	assert.Error(t, err)
+
+	configMap := &corev1.ConfigMap{Data: map[string]string{CABundleConfigMapKey: string(certPEM)}}
+	secret := &corev1.Secret{Data: map[string][]byte{ClientCertSecretKey: certPEM, ClientKeySecretKey: keyPEM}}
+
+	tlsConfig, err = getTLSConfig(configMap, secret)
+	assert.NoError(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Equal(t, 1, len(tlsConfig.Certificates))
+}
+
+func TestGetHelmIndexMTLS(t *testing.T) {
+	certPEM, keyPEM := newClientCertificate(t)
+
+	server, caBundle := newMTLSIndexServer(t, certPEM)
+	defer server.Close()
+
+	configMap := &corev1.ConfigMap{Data: map[string]string{CABundleConfigMapKey: caBundle}}
+	secret := &corev1.Secret{Data: map[string][]byte{ClientCertSecretKey: certPEM, ClientKeySecretKey: keyPEM}}
+
+	indexFile, _, _, err := GetHelmRepoIndex(context.TODO(), configMap, secret, "default", []string{server.URL}, "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, indexFile.Entries)
+
+	//The server certificate is not trusted without the caBundle
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), nil, secret, "default", []string{server.URL}, "")
+	assert.Error(t, err)
+
+	//The client certificate is required
+	_, _, _, err = GetHelmRepoIndex(context.TODO(), configMap, nil, "default", []string{server.URL}, "")
+	assert.Error(t, err)
+
+	auth, err := getGitAuth(configMap, secret, server.URL+"/repo.git")
+	assert.NoError(t, err)
+
+	clientAuth, ok := auth.(*gitClientAuth)
+	assert.True(t, ok)
+	assert.Nil(t, clientAuth.auth)
+	assert.Equal(t, 1, len(clientAuth.client.Transport.(*http.Transport).TLSClientConfig.Certificates))
+}
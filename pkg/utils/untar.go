@@ -0,0 +1,454 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+var (
+	//maxUntarSize is the maximum uncompressed size of a chart archive
+	maxUntarSize int64 = 100 << 20
+	//maxUntarFileSize is the maximum size of a file in a chart archive
+	maxUntarFileSize int64 = 5 << 20
+)
+
+var (
+	//ErrArchiveEntryOutsideDir the entry is absolute or escapes the destination directory
+	ErrArchiveEntryOutsideDir = errors.New("entry escapes the destination directory")
+	//ErrArchiveTooLarge the uncompressed archive exceeds the maximum size
+	ErrArchiveTooLarge = errors.New("uncompressed archive exceeds the maximum size")
+	//ErrArchiveFileTooLarge a file of the archive exceeds the maximum size
+	ErrArchiveFileTooLarge = errors.New("file exceeds the maximum size")
+	//ErrArchiveUnsafeLink a link points outside the destination directory or a file is written through a link
+	ErrArchiveUnsafeLink = errors.New("unsafe link")
+)
+
+//UntarError is returned when an entry of the archive is rejected
+type UntarError struct {
+	Entry string
+	Err   error
+}
+
+func (e *UntarError) Error() string {
+	return fmt.Sprintf("chart archive rejected, %s: %v", e.Entry, e.Err)
+}
+
+func (e *UntarError) Unwrap() error {
+	return e.Err
+}
+
+//sizeLimitReader fails with err when more than remaining bytes are read
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+
+	if l.remaining < 0 {
+		return n, l.err
+	}
+
+	return n, err
+}
+
+//Untar untars the reader into the dst directory, the entries which escape the dst directory,
+//the files written through a link and the archives exceeding the maximum sizes are rejected.
+//The archive is extracted into a temporary directory first, the top-level entries replace the
+//ones of dst only once the whole archive is accepted, nothing is left behind on error.
+func Untar(dst string, r io.Reader) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	defer gzr.Close()
+
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dst, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpDst, err := ioutil.TempDir(filepath.Dir(dst), "."+filepath.Base(dst)+"-untar")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmpDst)
+
+	err = untarInto(tmpDst, tar.NewReader(&sizeLimitReader{r: gzr, remaining: maxUntarSize, err: ErrArchiveTooLarge}))
+	if err != nil {
+		return err
+	}
+
+	return moveEntries(tmpDst, dst)
+}
+
+//moveEntries moves the entries of the src directory into the dst directory, replacing the existing ones
+func moveEntries(src string, dst string) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		target := filepath.Join(dst, entry.Name())
+
+		err = os.RemoveAll(target)
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(filepath.Join(src, entry.Name()), target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//untarInto extracts the archive into the empty dst directory
+func untarInto(dst string, tr *tar.Reader) error {
+	//The target of each symlink created
+	symlinks := make(map[string]string)
+
+	for {
+		header, err := tr.Next()
+
+		switch {
+		case err == io.EOF: // if no more files are found check the links
+			return checkSymlinks(dst, symlinks)
+		case errors.Is(err, ErrArchiveTooLarge):
+			klog.Error(err)
+			return &UntarError{Entry: dst, Err: err}
+		case err != nil: // return any other error
+			klog.Error(err)
+			return err
+		case header == nil: // if the header is nil, just skip it (not sure how this happens)
+			continue
+		}
+
+		// the target location where the dir/file should be created
+		target, err := untarTarget(dst, header.Name)
+		if err == nil {
+			//MkdirAll and OpenFile follow the links
+			err = checkNoSymlink(dst, filepath.Dir(target))
+		}
+
+		if err != nil {
+			klog.Error(err, " - ", header.Name)
+			return &UntarError{Entry: header.Name, Err: err}
+		}
+
+		//An entry replaces a previous symlink of the same name
+		if header.Typeflag != tar.TypeSymlink {
+			delete(symlinks, target)
+		}
+
+		// check the file type
+		switch header.Typeflag {
+		case tar.TypeDir: // if its a dir and it doesn't exist create it
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg, tar.TypeRegA: // if it's a file create it
+			err = untarFile(tr, target, header)
+		case tar.TypeSymlink:
+			err = untarSymlink(target, header)
+			symlinks[target] = header.Linkname
+		case tar.TypeLink:
+			err = untarHardlink(dst, target, header)
+		default:
+			klog.V(3).Info("Ignoring entry ", header.Name, " of type ", header.Typeflag)
+		}
+
+		if err != nil {
+			klog.Error(err, " - ", header.Name)
+
+			if isUntarRejection(err) {
+				return &UntarError{Entry: header.Name, Err: err}
+			}
+
+			return err
+		}
+	}
+}
+
+//chartArchiveDir returns the top-level directory of a chart archive, the directory is named after the
+//name of the Chart.yaml and not after the location the archive was downloaded from
+func chartArchiveDir(r io.Reader) (string, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("no chart directory found in the archive")
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(header.Name)), "./")
+		top := strings.SplitN(name, "/", 2)[0]
+
+		if top == "" || top == "." || top == ".." || filepath.IsAbs(header.Name) {
+			continue
+		}
+
+		return top, nil
+	}
+}
+
+//extractedChartDir returns the directory of the chart extracted into dir
+func extractedChartDir(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return entry.Name(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no chart directory found in the archive")
+}
+
+func isUntarRejection(err error) bool {
+	return errors.Is(err, ErrArchiveEntryOutsideDir) ||
+		errors.Is(err, ErrArchiveTooLarge) ||
+		errors.Is(err, ErrArchiveFileTooLarge) ||
+		errors.Is(err, ErrArchiveUnsafeLink)
+}
+
+//untarTarget returns the location of the entry, it fails if the entry is not in the dst directory
+func untarTarget(dst string, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", ErrArchiveEntryOutsideDir
+	}
+
+	target := filepath.Join(dst, name)
+	if !isInDir(dst, target) {
+		return "", ErrArchiveEntryOutsideDir
+	}
+
+	return target, nil
+}
+
+//isInDir returns true if the path is the dir or is located under the dir
+func isInDir(dir string, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+//checkNoSymlink fails if a directory between dst and dir is a symlink
+func checkNoSymlink(dst string, dir string) error {
+	for p := dir; isInDir(dst, p) && p != dst; p = filepath.Dir(p) {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			continue
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symlink", ErrArchiveUnsafeLink, p)
+		}
+	}
+
+	return nil
+}
+
+//removeExisting removes a previous version of the target, a link is removed and not followed
+func removeExisting(target string) {
+	if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+		klog.Info(fmt.Sprintf("A previous version exist of %s then delete", target))
+		os.Remove(target)
+	}
+}
+
+func untarFile(tr io.Reader, target string, header *tar.Header) error {
+	klog.V(3).Info("Untar to target :", target)
+
+	if header.Size > maxUntarFileSize {
+		return fmt.Errorf("%w: %d bytes", ErrArchiveFileTooLarge, header.Size)
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	removeExisting(target)
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	// copy over contents, the extra byte detects a file larger than its header
+	n, err := io.Copy(f, io.LimitReader(tr, maxUntarFileSize+1))
+	if err != nil {
+		return err
+	}
+
+	if n > maxUntarFileSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrArchiveFileTooLarge, maxUntarFileSize)
+	}
+
+	return nil
+}
+
+func untarSymlink(target string, header *tar.Header) error {
+	if filepath.IsAbs(header.Linkname) {
+		return fmt.Errorf("%w: absolute target %s", ErrArchiveUnsafeLink, header.Linkname)
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	removeExisting(target)
+
+	return os.Symlink(header.Linkname, target)
+}
+
+func untarHardlink(dst string, target string, header *tar.Header) error {
+	source, err := untarTarget(dst, header.Linkname)
+	if err != nil {
+		return fmt.Errorf("%w: target %s", ErrArchiveUnsafeLink, header.Linkname)
+	}
+
+	err = checkNoSymlink(dst, filepath.Dir(source))
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: target %s is not a regular file", ErrArchiveUnsafeLink, header.Linkname)
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	removeExisting(target)
+
+	return os.Link(source, target)
+}
+
+//maxSymlinkHops bounds the links followed to resolve a symlink, a loop is rejected
+const maxSymlinkHops = 255
+
+//checkSymlinks fails if a symlink resolves outside of the dst directory, the check is done once all
+//the links are created as a link can go through another link. The targets don't need to exist.
+func checkSymlinks(dst string, symlinks map[string]string) error {
+	for symlink := range symlinks {
+		err := resolveSymlink(dst, symlinks, symlink)
+		if err != nil {
+			rel, _ := filepath.Rel(dst, symlink)
+			return &UntarError{Entry: rel, Err: err}
+		}
+	}
+
+	return nil
+}
+
+//resolveSymlink resolves the target of the symlink lexically against the dst directory, the links met
+//along the path are followed with the symlinks of the archive as dst contains only the archive entries.
+//The path components are processed in order so that a .. applies to the target of a link and not to the link.
+func resolveSymlink(dst string, symlinks map[string]string, symlink string) error {
+	//The parent directories of the entries are not links
+	current := filepath.Dir(symlink)
+	remaining := strings.Split(filepath.ToSlash(symlinks[symlink]), "/")
+
+	for hops := 0; len(remaining) > 0; {
+		part := remaining[0]
+		remaining = remaining[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			if !isInDir(dst, current) {
+				return fmt.Errorf("%w: resolves outside of the destination directory", ErrArchiveUnsafeLink)
+			}
+
+			continue
+		}
+
+		next := filepath.Join(current, part)
+
+		linkname, ok := symlinks[next]
+		if !ok {
+			current = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops || filepath.IsAbs(linkname) {
+			return fmt.Errorf("%w: unable to resolve %s", ErrArchiveUnsafeLink, linkname)
+		}
+
+		remaining = append(strings.Split(filepath.ToSlash(linkname), "/"), remaining...)
+	}
+
+	return nil
+}
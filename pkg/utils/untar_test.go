@@ -0,0 +1,217 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+//newTarGz builds a gzipped tar archive from the entries
+func newTarGz(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+
+	for _, e := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		})
+		assert.NoError(t, err)
+
+		_, err = tw.Write([]byte(e.body))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gzw.Close())
+
+	return buf
+}
+
+func TestUntar(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "untar")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "chart")
+
+	err = Untar(dst, newTarGz(t, []tarEntry{
+		{name: "mychart/", typeflag: tar.TypeDir},
+		{name: "mychart/Chart.yaml", typeflag: tar.TypeReg, body: "name: mychart"},
+		{name: "mychart/templates/NOTES.txt", typeflag: tar.TypeReg, body: "notes"},
+		{name: "mychart/values.yaml", typeflag: tar.TypeSymlink, linkname: "templates/NOTES.txt"},
+		{name: "mychart/README", typeflag: tar.TypeLink, linkname: "mychart/Chart.yaml"},
+		{name: "mychart/dangling", typeflag: tar.TypeSymlink, linkname: "templates/missing.yaml"},
+	}))
+	assert.NoError(t, err)
+
+	_, err = os.Lstat(filepath.Join(dst, "mychart", "dangling"))
+	assert.NoError(t, err)
+
+	for file, content := range map[string]string{
+		"Chart.yaml":  "name: mychart",
+		"values.yaml": "notes",
+		"README":      "name: mychart",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dst, "mychart", file))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(b))
+	}
+}
+
+func TestChartArchiveDir(t *testing.T) {
+	name, err := chartArchiveDir(newTarGz(t, []tarEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "./ibm-myapp/Chart.yaml", typeflag: tar.TypeReg, body: "name: ibm-myapp"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "ibm-myapp", name)
+
+	_, err = chartArchiveDir(newTarGz(t, []tarEntry{}))
+	assert.Error(t, err)
+
+	_, err = chartArchiveDir(strings.NewReader("not an archive"))
+	assert.Error(t, err)
+}
+
+func TestUntarRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "untar")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	outside := filepath.Join(dir, "outside")
+
+	err = ioutil.WriteFile(outside, []byte("outside"), 0644)
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		entries []tarEntry
+		err     error
+	}{
+		"traversal": {
+			entries: []tarEntry{{name: "mychart/../../outside", typeflag: tar.TypeReg, body: "overwritten"}},
+			err:     ErrArchiveEntryOutsideDir,
+		},
+		"absolute": {
+			entries: []tarEntry{{name: outside, typeflag: tar.TypeReg, body: "overwritten"}},
+			err:     ErrArchiveEntryOutsideDir,
+		},
+		"file too large": {
+			entries: []tarEntry{{name: "mychart/values.yaml", typeflag: tar.TypeReg, body: strings.Repeat("a", 1025)}},
+			err:     ErrArchiveFileTooLarge,
+		},
+		"archive too large": {
+			entries: []tarEntry{
+				{name: "mychart/a", typeflag: tar.TypeReg, body: strings.Repeat("a", 1024)},
+				{name: "mychart/b", typeflag: tar.TypeReg, body: strings.Repeat("b", 1024)},
+				{name: "mychart/c", typeflag: tar.TypeReg, body: strings.Repeat("c", 1024)},
+			},
+			err: ErrArchiveTooLarge,
+		},
+		"absolute symlink": {
+			entries: []tarEntry{{name: "mychart/values.yaml", typeflag: tar.TypeSymlink, linkname: outside}},
+			err:     ErrArchiveUnsafeLink,
+		},
+		"escaping symlink": {
+			entries: []tarEntry{{name: "mychart/values.yaml", typeflag: tar.TypeSymlink, linkname: "../../outside"}},
+			err:     ErrArchiveUnsafeLink,
+		},
+		"escaping through a symlink": {
+			entries: []tarEntry{
+				{name: "mychart/templates/parent", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "mychart/values.yaml", typeflag: tar.TypeSymlink, linkname: "templates/parent/../../outside"},
+			},
+			err: ErrArchiveUnsafeLink,
+		},
+		"write through symlink": {
+			entries: []tarEntry{
+				{name: "mychart/templates", typeflag: tar.TypeSymlink, linkname: "../.."},
+				{name: "mychart/templates/outside", typeflag: tar.TypeReg, body: "overwritten"},
+			},
+			err: ErrArchiveUnsafeLink,
+		},
+		"escaping hardlink": {
+			entries: []tarEntry{{name: "mychart/values.yaml", typeflag: tar.TypeLink, linkname: "../outside"}},
+			err:     ErrArchiveUnsafeLink,
+		},
+	}
+
+	maxSize, maxFileSize := maxUntarSize, maxUntarFileSize
+	maxUntarSize, maxUntarFileSize = 3*1024, 1024
+
+	defer func() {
+		maxUntarSize, maxUntarFileSize = maxSize, maxFileSize
+	}()
+
+	for name, test := range tests {
+		dst := filepath.Join(dir, "chart")
+
+		err := os.MkdirAll(filepath.Join(dst, "mychart"), 0755)
+		assert.NoError(t, err)
+
+		err = ioutil.WriteFile(filepath.Join(dst, "mychart", "Chart.yaml"), []byte("previous"), 0644)
+		assert.NoError(t, err)
+
+		err = Untar(dst, newTarGz(t, test.entries))
+		assert.True(t, errors.Is(err, test.err), name, err)
+
+		var untarErr *UntarError
+		assert.True(t, errors.As(err, &untarErr), name)
+
+		b, err := ioutil.ReadFile(outside)
+		assert.NoError(t, err)
+		assert.Equal(t, "outside", string(b), name)
+
+		//The previous chart is kept and nothing of the archive is left behind
+		b, err = ioutil.ReadFile(filepath.Join(dst, "mychart", "Chart.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, "previous", string(b), name)
+
+		entries, err := ioutil.ReadDir(filepath.Join(dst, "mychart"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(entries), name)
+
+		entries, err = ioutil.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(entries), name)
+
+		os.RemoveAll(dst)
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	appv1alpha1 "github.com/IBM/multicloud-operators-subscription-release/pkg/apis/app/v1alpha1"
+)
+
+const (
+	//GitHubPath receives the push events of GitHub
+	GitHubPath = "/github"
+	//GitLabPath receives the push events of GitLab
+	GitLabPath = "/gitlab"
+	//GenericPath receives the repository changed notifications of the other sources, ChartMuseum for example
+	GenericPath = "/generic"
+)
+
+const (
+	//GitHubSignatureHeader holds the sha256 HMAC of the GitHub payload
+	GitHubSignatureHeader = "X-Hub-Signature-256"
+	//GitHubEventHeader holds the type of the GitHub event
+	GitHubEventHeader = "X-GitHub-Event"
+	//GitLabTokenHeader holds the secret token of the GitLab webhook
+	GitLabTokenHeader = "X-Gitlab-Token"
+	//GitLabEventHeader holds the type of the GitLab event
+	GitLabEventHeader = "X-Gitlab-Event"
+	//SignatureHeader holds the sha256 HMAC of the generic notification, as sha256=<hex>
+	SignatureHeader = "X-Signature-256"
+)
+
+const (
+	//maxPayloadSize the larger payloads are rejected
+	maxPayloadSize = 5 << 20
+	//shutdownTimeout the in-flight notifications are given this time to complete when the manager stops
+	shutdownTimeout = 10 * time.Second
+)
+
+//Syncer triggers the sync of the subscriptions of a repository
+type Syncer interface {
+	//TriggerSync returns the number of subscriptions triggered for the urls and the branch
+	TriggerSync(urls []string, branch string) int
+}
+
+//Receiver receives the notifications of the repositories and triggers the sync of the matching subscriptions
+type Receiver struct {
+	secret []byte
+	syncer Syncer
+}
+
+//GenericNotification is the payload of the generic notifications
+type GenericNotification struct {
+	//URL of the repository which changed
+	URL string `json:"url"`
+	//Branch which changed for a git repository, all the branches if empty
+	Branch string `json:"branch,omitempty"`
+}
+
+type gitHubPushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		HTMLURL  string `json:"html_url"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		GitURL   string `json:"git_url"`
+	} `json:"repository"`
+}
+
+type gitLabPushEvent struct {
+	Ref     string `json:"ref"`
+	Project struct {
+		WebURL     string `json:"web_url"`
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+	} `json:"project"`
+}
+
+//NewReceiver returns a receiver verifying the notifications with the secret
+func NewReceiver(secret []byte, syncer Syncer) *Receiver {
+	return &Receiver{secret: secret, syncer: syncer}
+}
+
+//AddToManager runs a receiver listening on addr with the manager, the secret is read from
+//the WEBHOOK_SECRET environment variable and is required.
+func AddToManager(mgr manager.Manager, addr string, syncer Syncer) error {
+	secret := os.Getenv(appv1alpha1.WebhookSecret)
+	if secret == "" {
+		return fmt.Errorf("%s must be set to run the webhook receiver", appv1alpha1.WebhookSecret)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           NewReceiver([]byte(secret), syncer),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		errCh := make(chan error, 1)
+
+		go func() {
+			klog.Info("Webhook receiver listening on ", addr)
+			errCh <- server.ListenAndServe()
+		}()
+
+		select {
+		case err := <-errCh:
+			return err
+		case <-stop:
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+
+			return server.Shutdown(ctx)
+		}
+	}))
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	//The notifications can't be verified without a secret
+	if len(r.secret) == 0 {
+		http.Error(w, "no secret configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxPayloadSize+1))
+	if err != nil {
+		http.Error(w, "unable to read the payload", http.StatusBadRequest)
+		return
+	}
+
+	if len(body) > maxPayloadSize {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var urls []string
+
+	var branch string
+
+	switch req.URL.Path {
+	case GitHubPath:
+		if !validSignature(req.Header.Get(GitHubSignatureHeader), body, r.secret) {
+			r.unauthorized(w, req)
+			return
+		}
+
+		switch req.Header.Get(GitHubEventHeader) {
+		case "ping":
+			w.WriteHeader(http.StatusOK)
+			return
+		case "push":
+			urls, branch, err = parseGitHubPushEvent(body)
+		default:
+			ignored(w, "event "+req.Header.Get(GitHubEventHeader))
+			return
+		}
+	case GitLabPath:
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get(GitLabTokenHeader)), r.secret) != 1 {
+			r.unauthorized(w, req)
+			return
+		}
+
+		if req.Header.Get(GitLabEventHeader) != "Push Hook" {
+			ignored(w, "event "+req.Header.Get(GitLabEventHeader))
+			return
+		}
+
+		urls, branch, err = parseGitLabPushEvent(body)
+	case GenericPath:
+		if !validSignature(req.Header.Get(SignatureHeader), body, r.secret) {
+			r.unauthorized(w, req)
+			return
+		}
+
+		urls, branch, err = parseGenericNotification(body)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err != nil {
+		klog.Error(err, " - Invalid notification received on ", req.URL.Path)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if urls == nil {
+		ignored(w, "not a branch")
+		return
+	}
+
+	triggered := r.syncer.TriggerSync(urls, branch)
+	klog.Info("Notification for ", urls, " branch ", branch, " triggered ", triggered, " subscriptions")
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "%d subscriptions triggered\n", triggered)
+}
+
+func (r *Receiver) unauthorized(w http.ResponseWriter, req *http.Request) {
+	klog.Info("Notification with an invalid signature received on ", req.URL.Path, " from ", req.RemoteAddr)
+	http.Error(w, "invalid signature", http.StatusUnauthorized)
+}
+
+func ignored(w http.ResponseWriter, reason string) {
+	klog.V(3).Info("Notification ignored: ", reason)
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "ignored, %s\n", reason)
+}
+
+//validSignature checks the sha256 HMAC of the body, the signature is sha256=<hex>
+func validSignature(signature string, body []byte, secret []byte) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+//branchOf returns the branch of a refs/heads/<branch> reference, false for the tags
+func branchOf(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ref, "refs/heads/"), true
+}
+
+//parseGitHubPushEvent returns the urls of the repository and the branch pushed, no url if a tag was pushed
+func parseGitHubPushEvent(body []byte) (urls []string, branch string, err error) {
+	event := &gitHubPushEvent{}
+
+	err = json.Unmarshal(body, event)
+	if err != nil {
+		return nil, "", err
+	}
+
+	branch, ok := branchOf(event.Ref)
+	if !ok {
+		return nil, "", nil
+	}
+
+	urls = nonEmpty(event.Repository.HTMLURL, event.Repository.CloneURL, event.Repository.SSHURL, event.Repository.GitURL)
+	if len(urls) == 0 {
+		return nil, "", fmt.Errorf("no repository url in the push event")
+	}
+
+	return urls, branch, nil
+}
+
+//parseGitLabPushEvent returns the urls of the project and the branch pushed, no url if a tag was pushed
+func parseGitLabPushEvent(body []byte) (urls []string, branch string, err error) {
+	event := &gitLabPushEvent{}
+
+	err = json.Unmarshal(body, event)
+	if err != nil {
+		return nil, "", err
+	}
+
+	branch, ok := branchOf(event.Ref)
+	if !ok {
+		return nil, "", nil
+	}
+
+	urls = nonEmpty(event.Project.WebURL, event.Project.GitHTTPURL, event.Project.GitSSHURL)
+	if len(urls) == 0 {
+		return nil, "", fmt.Errorf("no project url in the push event")
+	}
+
+	return urls, branch, nil
+}
+
+//parseGenericNotification returns the url and the branch of the notification
+func parseGenericNotification(body []byte) (urls []string, branch string, err error) {
+	notification := &GenericNotification{}
+
+	err = json.Unmarshal(body, notification)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if notification.URL == "" {
+		return nil, "", fmt.Errorf("no url in the notification")
+	}
+
+	return []string{notification.URL}, notification.Branch, nil
+}
+
+func nonEmpty(values ...string) []string {
+	result := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
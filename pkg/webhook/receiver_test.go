@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const secret = "secret"
+
+const gitHubPush = `{
+  "ref": "refs/heads/main",
+  "repository": {
+    "html_url": "https://github.com/org/charts",
+    "clone_url": "https://github.com/org/charts.git",
+    "ssh_url": "git@github.com:org/charts.git",
+    "git_url": "git://github.com/org/charts.git"
+  }
+}`
+
+const gitLabPush = `{
+  "ref": "refs/heads/release",
+  "project": {
+    "web_url": "https://gitlab.example.com/org/charts",
+    "git_http_url": "https://gitlab.example.com/org/charts.git",
+    "git_ssh_url": "git@gitlab.example.com:org/charts.git"
+  }
+}`
+
+type fakeSyncer struct {
+	urls   []string
+	branch string
+	calls  int
+}
+
+func (f *fakeSyncer) TriggerSync(urls []string, branch string) int {
+	f.urls = urls
+	f.branch = branch
+	f.calls++
+
+	return 1
+}
+
+func sign(body string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write([]byte(body))
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func notify(r *Receiver, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestGitHubPush(t *testing.T) {
+	syncer := &fakeSyncer{}
+	r := NewReceiver([]byte(secret), syncer)
+
+	w := notify(r, http.MethodPost, GitHubPath, gitHubPush, map[string]string{
+		GitHubEventHeader:     "push",
+		GitHubSignatureHeader: sign(gitHubPush, secret),
+	})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 1, syncer.calls)
+	assert.Equal(t, "main", syncer.branch)
+	assert.Equal(t, 4, len(syncer.urls))
+
+	w = notify(r, http.MethodPost, GitHubPath, gitHubPush, map[string]string{
+		GitHubEventHeader:     "push",
+		GitHubSignatureHeader: sign(gitHubPush, "other"),
+	})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = notify(r, http.MethodPost, GitHubPath, gitHubPush, map[string]string{GitHubEventHeader: "push"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = notify(r, http.MethodPost, GitHubPath, "{}", map[string]string{
+		GitHubEventHeader:     "ping",
+		GitHubSignatureHeader: sign("{}", secret),
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	//A tag push doesn't trigger a sync
+	tagPush := strings.Replace(gitHubPush, "refs/heads/main", "refs/tags/v1.0.0", 1)
+
+	w = notify(r, http.MethodPost, GitHubPath, tagPush, map[string]string{
+		GitHubEventHeader:     "push",
+		GitHubSignatureHeader: sign(tagPush, secret),
+	})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 1, syncer.calls)
+
+	w = notify(r, http.MethodGet, GitHubPath, "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestGitLabPush(t *testing.T) {
+	syncer := &fakeSyncer{}
+	r := NewReceiver([]byte(secret), syncer)
+
+	w := notify(r, http.MethodPost, GitLabPath, gitLabPush, map[string]string{
+		GitLabEventHeader: "Push Hook",
+		GitLabTokenHeader: secret,
+	})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "release", syncer.branch)
+	assert.Equal(t, []string{
+		"https://gitlab.example.com/org/charts",
+		"https://gitlab.example.com/org/charts.git",
+		"git@gitlab.example.com:org/charts.git",
+	}, syncer.urls)
+
+	w = notify(r, http.MethodPost, GitLabPath, gitLabPush, map[string]string{
+		GitLabEventHeader: "Push Hook",
+		GitLabTokenHeader: "other",
+	})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 1, syncer.calls)
+}
+
+func TestGenericNotification(t *testing.T) {
+	syncer := &fakeSyncer{}
+	r := NewReceiver([]byte(secret), syncer)
+
+	notification := `{"url": "https://charts.example.com"}`
+
+	w := notify(r, http.MethodPost, GenericPath, notification, map[string]string{SignatureHeader: sign(notification, secret)})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, []string{"https://charts.example.com"}, syncer.urls)
+	assert.Equal(t, "", syncer.branch)
+
+	w = notify(r, http.MethodPost, GenericPath, "{}", map[string]string{SignatureHeader: sign("{}", secret)})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = notify(r, http.MethodPost, "/unknown", notification, map[string]string{SignatureHeader: sign(notification, secret)})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	//No notification is accepted without a secret
+	w = notify(NewReceiver(nil, syncer), http.MethodPost, GitLabPath, gitLabPush, map[string]string{GitLabEventHeader: "Push Hook"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 1, syncer.calls)
+}